@@ -0,0 +1,129 @@
+package oidcproxy
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultPassthroughParameters are the authorization parameters which are
+// usually useful to pass through from a login request to the provider.
+var DefaultPassthroughParameters = []string{
+	"login_hint",
+	"prompt",
+	"ui_locales",
+	"acr_values",
+	"max_age",
+	"domain_hint",
+}
+
+// reservedAuthorizationParameters are set by the proxy itself and can not be
+// passed through.
+var reservedAuthorizationParameters = []string{
+	"client_id",
+	"redirect_uri",
+	"response_type",
+	"response_mode",
+	"scope",
+	"state",
+	"nonce",
+	"code_challenge",
+	"code_challenge_method",
+	"request",
+	"request_uri",
+}
+
+// maxAgeLeeway is the allowed clock skew between the proxy and the provider
+// when auth_time is checked against max_age.
+const maxAgeLeeway = time.Minute
+
+func validatePassthroughParameters(parameters []string) error {
+	for _, parameter := range parameters {
+		if slices.Contains(reservedAuthorizationParameters, parameter) {
+			return fmt.Errorf("authorization parameter '%s' can not be passed through", parameter)
+		}
+	}
+	return nil
+}
+
+// passthroughParameters returns the parameters of the login request query
+// which are allowed by the PassthroughParameters of the provider.
+func (p *Provider) passthroughParameters(query url.Values) url.Values {
+	parameters := url.Values{}
+	for _, parameter := range p.config.PassthroughParameters {
+		if query.Has(parameter) {
+			parameters[parameter] = slices.Clone(query[parameter])
+		}
+	}
+	return parameters
+}
+
+// maxAge returns the max_age of a login. A max_age of the login request
+// takes precedence over the configured authorization parameters. It returns
+// nil if no max_age is requested.
+func (p *Provider) maxAge(parameters url.Values) (*int, error) {
+	value := parameters.Get("max_age")
+	if value == "" {
+		value = p.config.AuthorizationParameter.Get("max_age")
+	}
+	if value == "" {
+		return nil, nil
+	}
+	maxAge, err := strconv.Atoi(value)
+	if err != nil || maxAge < 0 {
+		return nil, fmt.Errorf("invalid max_age '%s'", value)
+	}
+	return &maxAge, nil
+}
+
+// verifyMaxAge checks if the authentication of the session is not older than
+// maxAge seconds. If a max_age is requested the provider has to return the
+// auth_time claim (see
+// https://openid.net/specs/openid-connect-core-1_0.html#IDToken).
+func verifyMaxAge(s *Session, maxAge int) error {
+	if s.AuthTime.IsZero() {
+		return fmt.Errorf("max_age requested but auth_time missing")
+	}
+	if time.Since(s.AuthTime) > time.Duration(maxAge)*time.Second+maxAgeLeeway {
+		return fmt.Errorf("authentication at %s is older than max_age of %ds", s.AuthTime.Format(time.RFC3339), maxAge)
+	}
+	return nil
+}
+
+// interactionRequiredErrors are the error codes of an authentication response
+// to a silent login (prompt=none) which require an interactive login (see
+// https://openid.net/specs/openid-connect-core-1_0.html#AuthError).
+var interactionRequiredErrors = []string{
+	"login_required",
+	"interaction_required",
+	"consent_required",
+	"account_selection_required",
+}
+
+func interactionRequired(errorCode string) bool {
+	return slices.Contains(interactionRequiredErrors, errorCode)
+}
+
+// authCodeOpts returns the authorization parameters of the login state which
+// have to be sent again if the login is restarted.
+func (l *LoginState) authCodeOpts() []oauth2.AuthCodeOption {
+	opts := urlValuesIntoOpts(l.Parameters)
+	if l.MaxAge != nil {
+		opts = append(opts, oauth2.SetAuthURLParam("max_age", strconv.Itoa(*l.MaxAge)))
+	}
+	if l.ACRValues != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("acr_values", l.ACRValues))
+	}
+	return opts
+}
+
+// SupportsSilentLogin reports whether a silent login with prompt=none is
+// possible. This is only the case for OpenID Connect providers. OAuth2
+// providers (e.g. github) do not support the prompt parameter.
+func (p *Provider) SupportsSilentLogin() bool {
+	return p.isOpenIDConnect()
+}
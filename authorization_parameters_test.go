@@ -0,0 +1,60 @@
+package oidcproxy
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPassthroughParameters(t *testing.T) {
+	p := &Provider{config: &ProviderConfig{
+		PassthroughParameters:  []string{"login_hint", "max_age"},
+		AuthorizationParameter: url.Values{"max_age": {"3600"}},
+	}}
+
+	query := url.Values{
+		"provider":   {"p1"},
+		"login_hint": {"jane@example.com"},
+		"prompt":     {"login"},
+		"acr_values": {"mfa"},
+	}
+	parameters := p.passthroughParameters(query)
+	if len(parameters) != 1 || parameters.Get("login_hint") != "jane@example.com" {
+		t.Fatalf("unexpected parameters: %v", parameters)
+	}
+
+	maxAge, err := p.maxAge(parameters)
+	if err != nil || maxAge == nil || *maxAge != 3600 {
+		t.Fatalf("expected configured max_age, got %v, %v", maxAge, err)
+	}
+
+	parameters.Set("max_age", "0")
+	maxAge, err = p.maxAge(parameters)
+	if err != nil || maxAge == nil || *maxAge != 0 {
+		t.Fatalf("expected requested max_age, got %v, %v", maxAge, err)
+	}
+
+	parameters.Set("max_age", "-1")
+	_, err = p.maxAge(parameters)
+	if err == nil {
+		t.Fatal("expected error for negative max_age")
+	}
+}
+
+func TestVerifyMaxAge(t *testing.T) {
+	for _, test := range []struct {
+		authTime time.Time
+		maxAge   int
+		valid    bool
+	}{
+		{time.Time{}, 60, false},
+		{time.Now().Add(-30 * time.Second), 0, true},
+		{time.Now().Add(-time.Hour), 60, false},
+		{time.Now().Add(-time.Hour), 7200, true},
+	} {
+		err := verifyMaxAge(&Session{AuthTime: test.authTime}, test.maxAge)
+		if (err == nil) != test.valid {
+			t.Errorf("auth_time=%s max_age=%d: got err=%v, want valid=%t", test.authTime, test.maxAge, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+const backChannelLogoutEvent = "http://schemas.openid.net/event/backchannel-logout"
+
+// maxLogoutTokenAge is the maximum age of a logout token without an exp
+// claim.
+const maxLogoutTokenAge = time.Minute * 5
+
+// LogoutToken contains the relevant claims of a verified logout token. See
+// https://openid.net/specs/openid-connect-backchannel-1_0.html#LogoutToken.
+type LogoutToken struct {
+	Subject string
+	SID     string
+}
+
+// VerifyLogoutToken verifies a logout token of the back-channel logout.
+func (p *Provider) VerifyLogoutToken(ctx context.Context, rawLogoutToken string) (*LogoutToken, error) {
+	state, err := p.currentState()
+	if err != nil {
+		return nil, err
+	}
+	if state.oidcProvider == nil {
+		return nil, ErrNotSupported
+	}
+
+	// a logout token does not necessarily contain an exp claim. we check
+	// the expiry and the age of the token below.
+	token, err := p.verifyToken(ctx, state, oidc.Config{
+		ClientID:        p.config.ClientID,
+		SkipExpiryCheck: true,
+	}, rawLogoutToken)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := struct {
+		SID    string                     `json:"sid"`
+		Events map[string]json.RawMessage `json:"events"`
+		Nonce  *string                    `json:"nonce"`
+	}{}
+	err = token.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := claims.Events[backChannelLogoutEvent]; !ok {
+		return nil, fmt.Errorf("event %s missing", backChannelLogoutEvent)
+	}
+	if claims.Nonce != nil {
+		return nil, fmt.Errorf("logout token must not contain a nonce")
+	}
+	if claims.SID == "" && token.Subject == "" {
+		return nil, fmt.Errorf("logout token contains neither sid nor sub")
+	}
+
+	now := time.Now()
+	if !token.Expiry.IsZero() && token.Expiry.Before(now) {
+		return nil, fmt.Errorf("logout token expired at %s", token.Expiry)
+	}
+	if token.Expiry.IsZero() && now.Sub(token.IssuedAt) > maxLogoutTokenAge {
+		return nil, fmt.Errorf("logout token issued at %s is too old", token.IssuedAt)
+	}
+
+	return &LogoutToken{
+		Subject: token.Subject,
+		SID:     claims.SID,
+	}, nil
+}
+
+// BackChannelLogoutHandler implements the receiver of the OpenID Connect
+// Back-Channel Logout. The provider posts a logout token which identifies the
+// sessions to terminate by sid or sub. Matching sessions are added to the
+// RevocationStore of the session manager. The default store only keeps the
+// revocations in memory of this instance (see RevocationStore). See
+// https://openid.net/specs/openid-connect-backchannel-1_0.html.
+func BackChannelLogoutHandler(sm *sessionManager) http.Handler {
+	writeError := func(w http.ResponseWriter, description string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"error":             "invalid_request",
+			"error_description": description,
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		rawLogoutToken := r.PostFormValue("logout_token")
+		if rawLogoutToken == "" {
+			writeError(w, "logout_token missing")
+			return
+		}
+
+		issuer, audience, err := unverifiedIssuerAndAudience(rawLogoutToken)
+		if err != nil {
+			slog.Info("invalid logout token", "err", err)
+			writeError(w, "invalid logout_token")
+			return
+		}
+
+		verified := false
+		for _, provider := range sm.providerSet.List() {
+			if !provider.IssuerMatches(issuer) || !slices.Contains(audience, provider.config.ClientID) {
+				continue
+			}
+
+			logoutToken, err := provider.VerifyLogoutToken(r.Context(), rawLogoutToken)
+			if err != nil {
+				slog.Info("logout token verification failed", "provider", provider.String(), "err", err)
+				continue
+			}
+
+			// a logout token with a sid only terminates this session.
+			// without a sid all sessions of the subject are terminated.
+			if logoutToken.SID != "" {
+				err = sm.revocations.Revoke(r.Context(), provider.ID(), logoutToken.SID, "")
+			} else {
+				err = sm.revocations.Revoke(r.Context(), provider.ID(), "", logoutToken.Subject)
+			}
+			if err != nil {
+				slog.Warn("failed to revoke sessions", "provider", provider.String(), "err", err)
+				writeError(w, "logout failed")
+				return
+			}
+			slog.Info("back-channel logout", "provider", provider.String(), "sid", logoutToken.SID, "sub", logoutToken.Subject)
+			verified = true
+		}
+
+		if !verified {
+			writeError(w, "invalid logout_token")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// unverifiedIssuerAndAudience reads iss and aud from a JWT without verifying
+// it. This is used to find the provider which verifies the token.
+func unverifiedIssuerAndAudience(rawToken string) (string, []string, error) {
+	claims := struct {
+		Issuer   string          `json:"iss"`
+		Audience json.RawMessage `json:"aud"`
+	}{}
+	err := unverifiedClaims(rawToken, &claims)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// aud is either a string or an array of strings
+	var audience []string
+	if err := json.Unmarshal(claims.Audience, &audience); err != nil {
+		var single string
+		if err := json.Unmarshal(claims.Audience, &single); err != nil {
+			return "", nil, fmt.Errorf("invalid aud claim")
+		}
+		audience = []string{single}
+	}
+	return claims.Issuer, audience, nil
+}
+
+// unverifiedClaims decodes the claims of a JWT into v without verifying the
+// JWT.
+func unverifiedClaims(rawToken string, v any) error {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("malformed jwt")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("malformed jwt payload: %w", err)
+	}
+	err = json.Unmarshal(payload, v)
+	if err != nil {
+		return fmt.Errorf("malformed jwt claims: %w", err)
+	}
+	return nil
+}
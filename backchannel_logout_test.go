@@ -0,0 +1,162 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	jose "github.com/go-jose/go-jose/v3"
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+)
+
+const testIssuer = "https://idp.example.com"
+
+// newTestTokenProvider returns a provider which verifies tokens against the
+// keys of an httptest JWKS server and a function which signs claims with the
+// key of the server.
+func newTestTokenProvider(t *testing.T, config *ProviderConfig) (*Provider, func(claims map[string]any) string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"},
+		}})
+	}))
+	t.Cleanup(server.Close)
+
+	p := &Provider{id: "p1", config: config, httpClient: server.Client()}
+	p.state.Store(&providerState{
+		oidcProvider: (&oidc.ProviderConfig{IssuerURL: testIssuer, JWKSURL: server.URL}).NewProvider(context.Background()),
+	})
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: "k1"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(claims map[string]any) string {
+		token, err := josejwt.Signed(signer).Claims(claims).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	return p, sign
+}
+
+func TestVerifyLogoutToken(t *testing.T) {
+	p, sign := newTestTokenProvider(t, &ProviderConfig{ClientID: "client", IssuerURL: testIssuer})
+
+	claims := func(modify func(claims map[string]any)) map[string]any {
+		claims := map[string]any{
+			"iss":    testIssuer,
+			"aud":    "client",
+			"iat":    time.Now().Unix(),
+			"jti":    "id1",
+			"sub":    "user1",
+			"sid":    "sid1",
+			"events": map[string]any{backChannelLogoutEvent: map[string]any{}},
+		}
+		if modify != nil {
+			modify(claims)
+		}
+		return claims
+	}
+
+	for _, test := range []struct {
+		name   string
+		claims map[string]any
+		valid  bool
+	}{
+		{"valid", claims(nil), true},
+		{"only sub", claims(func(c map[string]any) { delete(c, "sid") }), true},
+		{"only sid", claims(func(c map[string]any) { delete(c, "sub") }), true},
+		{"neither sid nor sub", claims(func(c map[string]any) { delete(c, "sid"); delete(c, "sub") }), false},
+		{"events missing", claims(func(c map[string]any) { delete(c, "events") }), false},
+		{"other event", claims(func(c map[string]any) { c["events"] = map[string]any{"https://example.com/event": map[string]any{}} }), false},
+		{"nonce", claims(func(c map[string]any) { c["nonce"] = "nonce1" }), false},
+		{"too old", claims(func(c map[string]any) { c["iat"] = time.Now().Add(-maxLogoutTokenAge * 2).Unix() }), false},
+		{"old with exp", claims(func(c map[string]any) {
+			c["iat"] = time.Now().Add(-maxLogoutTokenAge * 2).Unix()
+			c["exp"] = time.Now().Add(time.Minute).Unix()
+		}), true},
+		{"expired", claims(func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() }), false},
+		{"other audience", claims(func(c map[string]any) { c["aud"] = "other-client" }), false},
+		{"other issuer", claims(func(c map[string]any) { c["iss"] = "https://other.example.com" }), false},
+	} {
+		logoutToken, err := p.VerifyLogoutToken(context.Background(), sign(test.claims))
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got err=%v, want valid=%t", test.name, err, test.valid)
+			continue
+		}
+		if err == nil && (logoutToken.SID != stringClaim(test.claims, "sid") || logoutToken.Subject != stringClaim(test.claims, "sub")) {
+			t.Errorf("%s: unexpected logout token %+v", test.name, logoutToken)
+		}
+	}
+}
+
+func stringClaim(claims map[string]any, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+func TestBackChannelLogoutHandler(t *testing.T) {
+	p, sign := newTestTokenProvider(t, &ProviderConfig{ClientID: "client", IssuerURL: testIssuer})
+	sm := newTestSessionManager(t, NewDefaultSessionManagerOptions(), p)
+	handler := BackChannelLogoutHandler(sm)
+
+	logout := func(claims map[string]any) int {
+		claims["iss"] = testIssuer
+		claims["aud"] = "client"
+		claims["iat"] = time.Now().Unix()
+		claims["events"] = map[string]any{backChannelLogoutEvent: map[string]any{}}
+		body := url.Values{"logout_token": {sign(claims)}}
+		r := httptest.NewRequest(http.MethodPost, "/auth/backchannel-logout", strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	created := time.Now().Add(-time.Minute)
+	session1 := &Session{ProviderID: p.ID(), Subject: "user1", SID: "sid1", Created: created}
+	session2 := &Session{ProviderID: p.ID(), Subject: "user1", SID: "sid2", Created: created}
+	session3 := &Session{ProviderID: p.ID(), Subject: "user2", SID: "sid3", Created: created}
+
+	// a token with sid only revokes the session with this sid
+	if code := logout(map[string]any{"sub": "user1", "sid": "sid1"}); code != http.StatusOK {
+		t.Fatalf("expected ok, got %d", code)
+	}
+	if !revoked(t, sm.revocations, session1) || revoked(t, sm.revocations, session2) {
+		t.Fatal("expected only session with sid1 to be revoked")
+	}
+
+	// a token without sid revokes all sessions of the subject
+	if code := logout(map[string]any{"sub": "user1"}); code != http.StatusOK {
+		t.Fatalf("expected ok, got %d", code)
+	}
+	if !revoked(t, sm.revocations, session2) || revoked(t, sm.revocations, session3) {
+		t.Fatal("expected all sessions of user1 to be revoked")
+	}
+
+	// invalid tokens are rejected
+	if code := logout(map[string]any{"sub": "user2", "nonce": "nonce1"}); code != http.StatusBadRequest {
+		t.Fatalf("expected bad request, got %d", code)
+	}
+	if revoked(t, sm.revocations, session3) {
+		t.Fatal("session revoked by invalid logout token")
+	}
+}
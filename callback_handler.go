@@ -4,13 +4,14 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 )
 
 type HTTPErrorHandler func(w http.ResponseWriter, r *http.Request, httpCode int, err error)
 
 type PostCallbackHandler func(w http.ResponseWriter, r *http.Request, s *SessionContext)
 
-func defaultPostCallbackHandler(sm *sessionManager, errorHandler HTTPErrorHandler, infoEndpoint string) func(w http.ResponseWriter, r *http.Request, s *SessionContext) {
+func defaultPostCallbackHandler(sm *sessionManager, redirectValidator *RedirectValidator, errorHandler HTTPErrorHandler, infoEndpoint string) func(w http.ResponseWriter, r *http.Request, s *SessionContext) {
 	return func(w http.ResponseWriter, r *http.Request, s *SessionContext) {
 		// persist new session
 		err := sm.SetSession(w, r, s.Session)
@@ -19,10 +20,13 @@ func defaultPostCallbackHandler(sm *sessionManager, errorHandler HTTPErrorHandle
 			return
 		}
 
-		loginState := sm.GetLoginState(w, r)
-
-		originURI := loginState.URI
-		if originURI == "" {
+		loginState := LoginStateFromContext(r.Context())
+		if loginState == nil || loginState.URI == "" {
+			http.Redirect(w, r, infoEndpoint, http.StatusSeeOther)
+			return
+		}
+		if !redirectValidator.Valid(loginState.URI) {
+			slog.Warn("redirect target not allowed", "uri", loginState.URI)
 			http.Redirect(w, r, infoEndpoint, http.StatusSeeOther)
 			return
 		}
@@ -40,26 +44,87 @@ func defaultErrorHandler(w http.ResponseWriter, r *http.Request, httpCode int, e
 	http.Error(w, message, httpCode)
 }
 
+// CallbackHandler handles the authorization response of the provider. It
+// exchanges the code for the tokens and passes the new session to the
+// postCallbackHandler. The login state is available in the request context of
+// the postCallbackHandler (see LoginStateFromContext). JWT secured
+// authorization responses (JARM) are verified before the parameters are used.
 func CallbackHandler(sm *sessionManager, postCallbackHandler PostCallbackHandler, errorHandler HTTPErrorHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		loginState := sm.GetLoginState(w, r)
-		if loginState == nil {
+		params, err := callbackParameters(r)
+		if err != nil {
+			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("invalid callback parameters"))
+			return
+		}
+
+		// with JARM the parameters are in the response JWT. it can only
+		// be verified after the provider is known from the login state.
+		state := params.Get("state")
+		response := params.Get("response")
+		if response != "" {
+			claims := struct {
+				State string `json:"state"`
+			}{}
+			err := unverifiedClaims(response, &claims)
+			if err != nil {
+				errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("invalid authorization response: %w", err))
+				return
+			}
+			state = claims.State
+		}
+		if state == "" {
 			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("state missing"))
 			return
 		}
 
-		sm.DeleteLoginState(w, r)
+		loginState := sm.GetLoginState(w, r, state)
+		if loginState == nil || loginState.Logout {
+			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("unknown or expired state"))
+			return
+		}
+
+		provider, err := sm.providerSet.GetByID(loginState.ProviderID)
+		if err != nil {
+			slog.Error("invalid provider", "err", err)
+			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("invalid provider id"))
+			return
+		}
+
+		if response != "" {
+			params, err = provider.DecodeAuthorizationResponse(r.Context(), response)
+			if err != nil {
+				slog.Info("login failed", "err", err)
+				errorHandler(w, r, http.StatusBadRequest, err)
+				return
+			}
+			if params.Get("state") != state {
+				errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("state mismatch in authorization response"))
+				return
+			}
+		} else if isJARMResponseMode(provider.config.ResponseMode) {
+			// do not accept unsigned responses if JARM is configured
+			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("authorization response jwt missing"))
+			return
+		}
 
-		state := r.URL.Query().Get("state")
-		if state != loginState.State {
-			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("state missmatch"))
+		// the silent login failed because the provider requires an
+		// interaction. restart the login interactively with a new
+		// state.
+		if loginState.Silent && interactionRequired(params.Get("error")) {
+			slog.Debug("silent login not possible: initiate interactive login", "error", params.Get("error"))
+			restartLogin(w, r, sm, provider, loginState)
 			return
 		}
 
-		params := r.URL.Query()
+		sm.DeleteLoginState(w, r, state)
+
 		if params.Get("error") != "" {
 			slog.Info("login failed", "error", params.Get("error"), "error_description", params.Get("error_description"))
-			errorHandler(w, r, http.StatusInternalServerError, fmt.Errorf("error=%s, error_description=%s", params.Get("error"), params.Get("error_description")))
+			httpCode := http.StatusInternalServerError
+			if interactionRequired(params.Get("error")) {
+				httpCode = http.StatusUnauthorized
+			}
+			errorHandler(w, r, httpCode, fmt.Errorf("error=%s, error_description=%s", params.Get("error"), params.Get("error_description")))
 			return
 		}
 
@@ -71,12 +136,6 @@ func CallbackHandler(sm *sessionManager, postCallbackHandler PostCallbackHandler
 		}
 		code := params.Get("code")
 
-		provider, err := sm.providerSet.GetByID(loginState.ProviderID)
-		if err != nil {
-			slog.Error("invalid provider", "err", err)
-			errorHandler(w, r, http.StatusBadRequest, fmt.Errorf("invalid provider id"))
-			return
-		}
 		newSession, err := provider.Exchange(r.Context(), code)
 		if err != nil {
 			slog.Info("session initialization failed", "err", err)
@@ -85,10 +144,127 @@ func CallbackHandler(sm *sessionManager, postCallbackHandler PostCallbackHandler
 			return
 		}
 
+		if loginState.MaxAge != nil {
+			err = verifyMaxAge(newSession, *loginState.MaxAge)
+			if err != nil {
+				slog.Info("session initialization failed", "err", err)
+				errorHandler(w, r, http.StatusUnauthorized, err)
+				return
+			}
+		}
+
+		if loginState.ACRValues != "" {
+			err = verifyACR(newSession, loginState.ACRValues)
+			if err != nil {
+				slog.Info("session initialization failed", "err", err)
+				errorHandler(w, r, http.StatusForbidden, err)
+				return
+			}
+		}
+
+		if len(loginState.AMR) > 0 {
+			err = verifyAMR(newSession, loginState.AMR)
+			if err != nil {
+				slog.Info("session initialization failed", "err", err)
+				errorHandler(w, r, http.StatusForbidden, err)
+				return
+			}
+		}
+
+		sm.BindSession(r, newSession)
+		sm.SetLastProvider(w, r, provider.ID())
+
 		slog.Info("session initiated", "refresh_token", newSession.HasRefreshToken())
+		r = r.WithContext(ContextWithLoginState(r.Context(), loginState))
 		postCallbackHandler(w, r, &SessionContext{
 			Session:  newSession,
 			Provider: provider,
 		})
 	})
 }
+
+// callbackParameters returns the parameters of the authorization response.
+// With the response modes form_post and form_post.jwt they are sent in the
+// body of a POST request.
+func callbackParameters(r *http.Request) (url.Values, error) {
+	if r.Method != http.MethodPost {
+		return r.URL.Query(), nil
+	}
+	err := r.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+	return r.PostForm, nil
+}
+
+// formPostResubmitParameter marks a form_post callback which was posted again
+// by the page of FormPostHandler.
+const formPostResubmitParameter = "oprox_resubmit"
+
+// FormPostHandler handles callbacks with the response mode form_post (and
+// form_post.jwt). Browsers do not send cookies with SameSite=Lax or Strict on
+// the cross-site POST of the provider. If the login state cookie is missing
+// and the body contains an authorization response (state or response), the
+// handler responds with a page which posts the parameters again from our own
+// site where the cookies are sent. Otherwise the request is passed to next.
+// The page submits the form with a script which is allowed by a nonce in the
+// Content-Security-Policy.
+func FormPostHandler(sm *sessionManager, tm *templateManager, next http.Handler) http.Handler {
+	type Parameter struct {
+		Name  string
+		Value string
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, "invalid form", http.StatusBadRequest)
+			return
+		}
+
+		if len(getCookies(r, sm.loginStateCookieName)) > 0 || r.PostForm.Has(formPostResubmitParameter) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !r.PostForm.Has("state") && !r.PostForm.Has("response") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		nonce, err := randString(16)
+		if err != nil {
+			slog.Error("faild to generate random nonce", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		data := struct {
+			Action     string
+			Nonce      string
+			Parameters []Parameter
+		}{
+			Action: r.URL.RequestURI(),
+			Nonce:  nonce,
+			Parameters: []Parameter{
+				{Name: formPostResubmitParameter, Value: "1"},
+			},
+		}
+		for name, values := range r.PostForm {
+			for _, value := range values {
+				data.Parameters = append(data.Parameters, Parameter{Name: name, Value: value})
+			}
+		}
+
+		slog.Debug("login state cookie missing on form post: resubmit from own site")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src 'nonce-"+nonce+"'; form-action 'self'")
+		tm.servePage(w, "form_post", data)
+	})
+}
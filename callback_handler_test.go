@@ -0,0 +1,119 @@
+package oidcproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCallbackHandlerSilentLogin(t *testing.T) {
+	provider := newTestAuthProvider(&ProviderConfig{})
+	sm := newTestSessionManager(t, NewDefaultSessionManagerOptions(), provider)
+
+	recorder := httptest.NewRecorder()
+	err := sm.SetLoginState(recorder, httptest.NewRequest(http.MethodGet, "/", nil), &LoginState{
+		ProviderID: provider.ID(),
+		State:      "state1",
+		URI:        "/app",
+		Silent:     true,
+		Parameters: url.Values{"login_hint": {"jane@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginStateCookie := recorder.Result().Cookies()[0]
+
+	handler := CallbackHandler(sm, nil, defaultErrorHandler)
+
+	// the interaction required error of a silent login restarts the login
+	recorder = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/callback?state=state1&error=login_required", nil)
+	r.AddCookie(loginStateCookie)
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect, got %d", recorder.Code)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the restarted login uses a new state and keeps the passed through
+	// parameters
+	state := location.Query().Get("state")
+	if location.Host != "idp.example.com" || state == "" || state == "state1" || location.Query().Has("prompt") || location.Query().Get("login_hint") != "jane@example.com" {
+		t.Fatalf("unexpected redirect to %s", location)
+	}
+	loginStateCookie = recorder.Result().Cookies()[0]
+
+	// the old state is no longer valid
+	recorder = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/callback?state=state1&error=login_required", nil)
+	r.AddCookie(loginStateCookie)
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected bad request for old state, got %d", recorder.Code)
+	}
+
+	// the interactive login does not get restarted again
+	recorder = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/callback?state="+state+"&error=login_required", nil)
+	r.AddCookie(loginStateCookie)
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unauthorized, got %d", recorder.Code)
+	}
+}
+
+func TestFormPostHandler(t *testing.T) {
+	sm := newTestSessionManager(t, NewDefaultSessionManagerOptions())
+	tm, err := NewTemplateManager("", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var called bool
+	handler := FormPostHandler(sm, tm, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	newRequest := func(body url.Values) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/auth/callback", strings.NewReader(body.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	// cross-site post without cookies gets posted again
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest(url.Values{"code": {"code1"}, "state": {"state1"}}))
+	if called {
+		t.Fatal("callback handler called without login state cookie")
+	}
+	page := recorder.Body.String()
+	for _, field := range []string{`name="code" value="code1"`, `name="state" value="state1"`, `name="oprox_resubmit" value="1"`} {
+		if !strings.Contains(page, field) {
+			t.Errorf("field %s missing in page: %s", field, page)
+		}
+	}
+
+	// the script is allowed by the nonce of the content security policy
+	csp := recorder.Header().Get("Content-Security-Policy")
+	nonce, _, _ := strings.Cut(strings.TrimPrefix(csp[strings.Index(csp, "'nonce-"):], "'nonce-"), "'")
+	if nonce == "" || !strings.Contains(page, `<script nonce="`+nonce+`">`) {
+		t.Errorf("script nonce missing in page or policy '%s': %s", csp, page)
+	}
+
+	// posts without authorization response are not posted again
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest(url.Values{"foo": {"bar"}}))
+	if !called {
+		t.Fatal("callback handler not called for post without state")
+	}
+	called = false
+
+	// the resubmitted post is passed on even if the cookie is still missing
+	handler.ServeHTTP(httptest.NewRecorder(), newRequest(url.Values{"code": {"code1"}, "state": {"state1"}, formPostResubmitParameter: {"1"}}))
+	if !called {
+		t.Fatal("callback handler not called on resubmit")
+	}
+}
@@ -0,0 +1,372 @@
+package oidcproxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/oauth2"
+)
+
+// ClientAuthMethod is the method a client uses to authenticate at the token
+// endpoint, the revocation endpoint and the introspection endpoint. See
+// https://openid.net/specs/openid-connect-core-1_0.html#ClientAuthentication.
+type ClientAuthMethod string
+
+const (
+	ClientSecretBasic ClientAuthMethod = "client_secret_basic"
+	ClientSecretPost  ClientAuthMethod = "client_secret_post"
+	ClientSecretJWT   ClientAuthMethod = "client_secret_jwt"
+	PrivateKeyJWT     ClientAuthMethod = "private_key_jwt"
+	// TLSClientAuth and SelfSignedTLSClientAuth authenticate the client
+	// with a TLS client certificate (see
+	// https://www.rfc-editor.org/rfc/rfc8705#section-2).
+	TLSClientAuth           ClientAuthMethod = "tls_client_auth"
+	SelfSignedTLSClientAuth ClientAuthMethod = "self_signed_tls_client_auth"
+	// ClientAuthNone is used by public clients which only send their
+	// client_id.
+	ClientAuthNone ClientAuthMethod = "none"
+
+	// clientSecretAutoDetect is selected for a client secret if the
+	// provider does not announce the supported methods. Token requests
+	// try client_secret_basic and fall back to client_secret_post
+	// (oauth2.AuthStyleAutoDetect). Other requests use
+	// client_secret_basic.
+	clientSecretAutoDetect ClientAuthMethod = "client_secret_auto_detect"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionAlgorithms are the algorithms which can be configured to sign
+// client assertions.
+var clientAssertionAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+	jose.HS256, jose.HS384, jose.HS512,
+}
+
+// clientAssertionLifetime is the lifetime of a client assertion JWT.
+const clientAssertionLifetime = time.Minute
+
+// selectClientAuthMethod returns the client authentication method. An
+// explicitly configured method takes precedence. Otherwise the first method
+// supported by the endpoint (supported is the *_auth_methods_supported list
+// from the discovery) for which the required credentials are available is
+// selected.
+func (p *Provider) selectClientAuthMethod(supported []string) (ClientAuthMethod, error) {
+	if p.config.ClientAuthMethod != "" {
+		return p.config.ClientAuthMethod, nil
+	}
+
+	candidates := []ClientAuthMethod{}
+	if p.config.ClientKey != nil {
+		candidates = append(candidates, PrivateKeyJWT)
+	}
+	if p.config.HTTPClientConfig.TLSClientCert != nil {
+		candidates = append(candidates, TLSClientAuth, SelfSignedTLSClientAuth)
+	}
+	if p.config.ClientSecret != "" {
+		candidates = append(candidates, ClientSecretBasic, ClientSecretPost, ClientSecretJWT)
+	}
+
+	if len(candidates) == 0 {
+		return ClientAuthNone, nil
+	}
+
+	// if omitted the default is client_secret_basic
+	if len(supported) == 0 {
+		if p.config.ClientSecret != "" {
+			return clientSecretAutoDetect, nil
+		}
+		supported = []string{string(ClientSecretBasic)}
+	}
+
+	for _, candidate := range candidates {
+		if slices.Contains(supported, string(candidate)) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("none of the supported client authentication methods %v is configured", supported)
+}
+
+func validateClientAuthMethod(config *ProviderConfig) error {
+	switch config.ClientAuthMethod {
+	case "", ClientAuthNone:
+	case ClientSecretBasic, ClientSecretPost, ClientSecretJWT:
+		if config.ClientSecret == "" {
+			return fmt.Errorf("client authentication method %s requires a client secret", config.ClientAuthMethod)
+		}
+	case PrivateKeyJWT:
+		if config.ClientKey == nil {
+			return fmt.Errorf("client authentication method %s requires a client key", config.ClientAuthMethod)
+		}
+	case TLSClientAuth, SelfSignedTLSClientAuth:
+		if config.HTTPClientConfig.TLSClientCert == nil {
+			return fmt.Errorf("client authentication method %s requires a tls client certificate", config.ClientAuthMethod)
+		}
+	default:
+		return fmt.Errorf("unknown client authentication method '%s'", config.ClientAuthMethod)
+	}
+
+	if config.ClientAssertionAlgorithm != "" && !slices.Contains(clientAssertionAlgorithms, jose.SignatureAlgorithm(config.ClientAssertionAlgorithm)) {
+		return fmt.Errorf("unsupported client assertion algorithm '%s'", config.ClientAssertionAlgorithm)
+	}
+	return nil
+}
+
+// usesClientSecret returns true if the client secret is sent to the provider.
+func (m ClientAuthMethod) usesClientSecret() bool {
+	return m == ClientSecretBasic || m == ClientSecretPost || m == clientSecretAutoDetect
+}
+
+// usesTLSClientCert returns true if the client authenticates with a TLS
+// client certificate.
+func (m ClientAuthMethod) usesTLSClientCert() bool {
+	return m == TLSClientAuth || m == SelfSignedTLSClientAuth
+}
+
+// usesClientAssertion returns true if the client authenticates with a signed
+// JWT.
+func (m ClientAuthMethod) usesClientAssertion() bool {
+	return m == PrivateKeyJWT || m == ClientSecretJWT
+}
+
+// oauth2AuthStyle returns the oauth2.AuthStyle for the client authentication
+// method.
+func oauth2AuthStyle(method ClientAuthMethod) oauth2.AuthStyle {
+	switch method {
+	case ClientSecretBasic:
+		return oauth2.AuthStyleInHeader
+	case ClientSecretPost, ClientAuthNone, TLSClientAuth, SelfSignedTLSClientAuth:
+		return oauth2.AuthStyleInParams
+	case clientSecretAutoDetect:
+		return oauth2.AuthStyleAutoDetect
+	default:
+		return oauth2.AuthStyleAutoDetect
+	}
+}
+
+// authenticateRequest adds the client authentication to a request with the
+// form body.
+func (p *Provider) authenticateRequest(state *providerState, method ClientAuthMethod, req *http.Request, body url.Values) error {
+	switch method {
+	case ClientSecretBasic, clientSecretAutoDetect:
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	case ClientSecretPost:
+		body.Set("client_id", p.config.ClientID)
+		body.Set("client_secret", p.config.ClientSecret)
+	case PrivateKeyJWT, ClientSecretJWT:
+		assertion, err := p.clientAssertion(state, method)
+		if err != nil {
+			return err
+		}
+		body.Set("client_id", p.config.ClientID)
+		body.Set("client_assertion_type", clientAssertionType)
+		body.Set("client_assertion", assertion)
+	case ClientAuthNone, TLSClientAuth, SelfSignedTLSClientAuth:
+		// with mTLS the client certificate is presented by the
+		// transport of the http client
+		body.Set("client_id", p.config.ClientID)
+	default:
+		return fmt.Errorf("unsupported client authentication method '%s'", method)
+	}
+	return nil
+}
+
+// clientAssertion returns a JWT to authenticate the client (see
+// https://www.rfc-editor.org/rfc/rfc7523). With private_key_jwt the JWT is
+// signed with the client key and with client_secret_jwt it is signed with the
+// client secret. The audience is the token endpoint.
+func (p *Provider) clientAssertion(state *providerState, method ClientAuthMethod) (string, error) {
+	signer, err := p.clientAssertionSigner(method)
+	if err != nil {
+		return "", err
+	}
+	return p.signClientAssertion(signer, state.endpoints.TokenEndpoint)
+}
+
+func (p *Provider) clientAssertionSigner(method ClientAuthMethod) (jose.Signer, error) {
+	return p.jwtSigner(method, "JWT")
+}
+
+// jwtSigner returns a signer which signs JWTs of the type typ with the client
+// key (private_key_jwt) or with the client secret (client_secret_jwt).
+func (p *Provider) jwtSigner(method ClientAuthMethod, typ jose.ContentType) (jose.Signer, error) {
+	var (
+		key       any
+		algorithm = jose.SignatureAlgorithm(p.config.ClientAssertionAlgorithm)
+	)
+	switch method {
+	case PrivateKeyJWT:
+		if p.config.ClientKey == nil {
+			return nil, fmt.Errorf("client key missing")
+		}
+		if algorithm == "" {
+			var err error
+			algorithm, err = signatureAlgorithm(p.config.ClientKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+		key = jose.JSONWebKey{
+			Key:   p.config.ClientKey,
+			KeyID: p.config.ClientKeyID,
+		}
+	case ClientSecretJWT:
+		if p.config.ClientSecret == "" {
+			return nil, fmt.Errorf("client secret missing")
+		}
+		// the configured algorithm is meant for the client key
+		if algorithm == "" || !strings.HasPrefix(string(algorithm), "HS") {
+			algorithm = jose.HS256
+		}
+		key = []byte(p.config.ClientSecret)
+	default:
+		return nil, fmt.Errorf("client authentication method %s does not use client assertions", method)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: algorithm,
+		Key:       key,
+	}, (&jose.SignerOptions{}).WithType(typ))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer: %w", err)
+	}
+	return signer, nil
+}
+
+func (p *Provider) signClientAssertion(signer jose.Signer, audience string) (string, error) {
+	id, err := randString(16)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := josejwt.Claims{
+		Issuer:    p.config.ClientID,
+		Subject:   p.config.ClientID,
+		Audience:  josejwt.Audience{audience},
+		ID:        id,
+		IssuedAt:  josejwt.NewNumericDate(now),
+		NotBefore: josejwt.NewNumericDate(now),
+		Expiry:    josejwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}
+	assertion, err := josejwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+	return assertion, nil
+}
+
+// signatureAlgorithm returns the default signature algorithm for key.
+func signatureAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		return jose.RS256, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	case ed25519.PublicKey:
+		return jose.EdDSA, nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", key.Public())
+}
+
+// LoadClientKey reads a PEM encoded private key (PKCS #8, PKCS #1 or SEC 1)
+// from file.
+func LoadClientKey(file string) (crypto.Signer, error) {
+	rawKey, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(rawKey)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block found in '%s'", file)
+	}
+
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported pem block type '%s' in '%s'", block.Type, file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key '%s': %w", file, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T in '%s'", key, file)
+	}
+	return signer, nil
+}
+
+// clientAssertionTransport adds a client assertion to the requests to the
+// token endpoint. This is used for the requests of the oauth2 package which
+// does not support client assertions.
+type clientAssertionTransport struct {
+	base     http.RoundTripper
+	provider *Provider
+	state    *providerState
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.Body == nil || !sameEndpoint(req.URL, t.state.endpoints.TokenEndpoint) {
+		return t.base.RoundTrip(req)
+	}
+
+	// the request must not be modified. only its body may be consumed.
+	authReq := req.Clone(req.Context())
+	rawBody, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body, err := url.ParseQuery(string(rawBody))
+	if err != nil {
+		return nil, err
+	}
+
+	body.Del("client_secret")
+	err = t.provider.authenticateRequest(t.state, t.state.tokenAuthMethod, authReq, body)
+	if err != nil {
+		return nil, err
+	}
+	setFormBody(authReq, body)
+	return t.base.RoundTrip(authReq)
+}
+
+func sameEndpoint(u *url.URL, endpoint string) bool {
+	e, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == e.Scheme && u.Host == e.Host && u.Path == e.Path
+}
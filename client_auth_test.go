@@ -0,0 +1,200 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/oauth2"
+)
+
+func TestClientAssertion(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Provider{
+		config: &ProviderConfig{
+			ClientID:     "client",
+			ClientSecret: "a-secret-with-at-least-32-bytes!",
+			ClientKey:    key,
+			ClientKeyID:  "key-1",
+		},
+	}
+	state := &providerState{
+		endpoints: Endpoints{
+			TokenEndpoint: "https://idp.example.com/token",
+		},
+	}
+
+	for _, test := range []struct {
+		method    ClientAuthMethod
+		algorithm string
+		keyID     string
+		verifyKey any
+	}{
+		{PrivateKeyJWT, "ES256", "key-1", &key.PublicKey},
+		{ClientSecretJWT, "HS256", "", []byte(p.config.ClientSecret)},
+	} {
+		t.Run(string(test.method), func(t *testing.T) {
+			assertion, err := p.clientAssertion(state, test.method)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			token, err := josejwt.ParseSigned(assertion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Headers[0].Algorithm != test.algorithm {
+				t.Errorf("got algorithm %s, want %s", token.Headers[0].Algorithm, test.algorithm)
+			}
+			if token.Headers[0].KeyID != test.keyID {
+				t.Errorf("got key id '%s', want '%s'", token.Headers[0].KeyID, test.keyID)
+			}
+
+			claims := josejwt.Claims{}
+			err = token.Claims(test.verifyKey, &claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = claims.Validate(josejwt.Expected{
+				Issuer:   "client",
+				Subject:  "client",
+				Audience: josejwt.Audience{"https://idp.example.com/token"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.ID == "" {
+				t.Error("jti missing")
+			}
+		})
+	}
+}
+
+func TestSelectClientAuthMethod(t *testing.T) {
+	p := &Provider{
+		config: &ProviderConfig{
+			ClientSecret: "secret",
+		},
+	}
+
+	for _, test := range []struct {
+		supported []string
+		expected  ClientAuthMethod
+		authStyle oauth2.AuthStyle
+		err       bool
+	}{
+		{nil, clientSecretAutoDetect, oauth2.AuthStyleAutoDetect, false},
+		{[]string{"client_secret_post", "client_secret_basic"}, ClientSecretBasic, oauth2.AuthStyleInHeader, false},
+		{[]string{"client_secret_post"}, ClientSecretPost, oauth2.AuthStyleInParams, false},
+		{[]string{"private_key_jwt", "client_secret_jwt"}, ClientSecretJWT, oauth2.AuthStyleAutoDetect, false},
+		{[]string{"private_key_jwt"}, "", oauth2.AuthStyleAutoDetect, true},
+	} {
+		method, err := p.selectClientAuthMethod(test.supported)
+		if test.err != (err != nil) {
+			t.Errorf("%v: unexpected error: %v", test.supported, err)
+		}
+		if method != test.expected {
+			t.Errorf("%v: got %s, want %s", test.supported, method, test.expected)
+		}
+		if authStyle := oauth2AuthStyle(method); authStyle != test.authStyle {
+			t.Errorf("%v: got auth style %d, want %d", test.supported, authStyle, test.authStyle)
+		}
+	}
+}
+
+func TestClientAssertionTokenRequests(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		tokenEndpoint string
+		jtis          = map[string]bool{}
+		mu            sync.Mutex
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		err := func() error {
+			if r.FormValue("client_secret") != "" {
+				return fmt.Errorf("client_secret sent")
+			}
+			if r.FormValue("client_assertion_type") != clientAssertionType {
+				return fmt.Errorf("invalid client_assertion_type '%s'", r.FormValue("client_assertion_type"))
+			}
+			token, err := josejwt.ParseSigned(r.FormValue("client_assertion"))
+			if err != nil {
+				return err
+			}
+			claims := josejwt.Claims{}
+			err = token.Claims(&key.PublicKey, &claims)
+			if err != nil {
+				return err
+			}
+			err = claims.Validate(josejwt.Expected{
+				Issuer:   "client",
+				Subject:  "client",
+				Audience: josejwt.Audience{tokenEndpoint},
+				Time:     time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			if claims.ID == "" || jtis[claims.ID] {
+				return fmt.Errorf("jti '%s' missing or reused", claims.ID)
+			}
+			jtis[claims.ID] = true
+			return nil
+		}()
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = fmt.Fprintf(w, `{"error": "invalid_client", "error_description": %q}`, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "at", "refresh_token": "rt", "token_type": "bearer", "expires_in": 60}`))
+	}))
+	defer server.Close()
+	tokenEndpoint = server.URL + "/token"
+
+	p, err := NewProvider(context.Background(), ProviderConfig{
+		Type:             ProviderTypeOAuth2,
+		ClientID:         "client",
+		ClientKey:        key,
+		ClientAuthMethod: PrivateKeyJWT,
+		Endpoints: Endpoints{
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         tokenEndpoint,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := p.Exchange(context.Background(), "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Refresh(context.Background(), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jtis) != 2 {
+		t.Fatalf("expected 2 client assertions, got %d", len(jtis))
+	}
+}
@@ -0,0 +1,82 @@
+package oidcproxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// ClientMetadata contains the client metadata (see
+// https://openid.net/specs/openid-connect-registration-1_0.html#ClientMetadata)
+// which has to be registered at a provider.
+type ClientMetadata struct {
+	ProviderID                            string   `json:"provider_id"`
+	ProviderName                          string   `json:"provider_name,omitempty"`
+	ClientID                              string   `json:"client_id"`
+	RedirectURIs                          []string `json:"redirect_uris"`
+	PostLogoutRedirectURIs                []string `json:"post_logout_redirect_uris,omitempty"`
+	FrontChannelLogoutURI                 string   `json:"frontchannel_logout_uri,omitempty"`
+	FrontChannelLogoutSessionRequired     bool     `json:"frontchannel_logout_session_required,omitempty"`
+	BackChannelLogoutURI                  string   `json:"backchannel_logout_uri,omitempty"`
+	BackChannelLogoutSessionRequired      bool     `json:"backchannel_logout_session_required,omitempty"`
+	TokenEndpointAuthMethod               string   `json:"token_endpoint_auth_method,omitempty"`
+	TLSClientCertificateBoundAccessTokens bool     `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+}
+
+// ClientMetadataHandler shows the client metadata of each provider. This
+// helps to register the proxy at the providers. The URLs are made absolute
+// based on the callback URL of the provider.
+func ClientMetadataHandler(providers []*Provider, frontChannelLogoutPath, backChannelLogoutPath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadata := []ClientMetadata{}
+		for _, p := range providers {
+			m, err := p.clientMetadata(frontChannelLogoutPath, backChannelLogoutPath)
+			if err != nil {
+				slog.Warn("invalid callback url", "provider", p.String(), "err", err)
+				continue
+			}
+			metadata = append(metadata, *m)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(metadata)
+		if err != nil {
+			slog.Info("failed to encode client metadata", "err", err)
+		}
+	})
+}
+
+func (p *Provider) clientMetadata(frontChannelLogoutPath, backChannelLogoutPath string) (*ClientMetadata, error) {
+	m := &ClientMetadata{
+		ProviderID:   p.ID(),
+		ProviderName: p.config.Name,
+		ClientID:     p.config.ClientID,
+		RedirectURIs: []string{p.config.CallbackURL},
+
+		TLSClientCertificateBoundAccessTokens: p.config.TLSClientCertificateBoundAccessTokens,
+	}
+	if p.config.PostLogoutRedirectURI != "" {
+		m.PostLogoutRedirectURIs = []string{p.config.PostLogoutRedirectURI}
+	}
+
+	// the authentication method is only known after the discovery. if it
+	// is auto-detected the default (client_secret_basic) is registered.
+	if state := p.state.Load(); state != nil && state.tokenAuthMethod != clientSecretAutoDetect {
+		m.TokenEndpointAuthMethod = string(state.tokenAuthMethod)
+	}
+
+	base, err := url.Parse(p.config.CallbackURL)
+	if err != nil {
+		return nil, err
+	}
+	if frontChannelLogoutPath != "" {
+		m.FrontChannelLogoutURI = base.ResolveReference(&url.URL{Path: frontChannelLogoutPath}).String()
+		m.FrontChannelLogoutSessionRequired = true
+	}
+	if backChannelLogoutPath != "" {
+		m.BackChannelLogoutURI = base.ResolveReference(&url.URL{Path: backChannelLogoutPath}).String()
+		m.BackChannelLogoutSessionRequired = true
+	}
+	return m, nil
+}
@@ -2,8 +2,11 @@ package oidcproxy
 
 import (
 	"fmt"
+	"log/slog"
 	"net/url"
 	pathpkg "path"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -14,7 +17,8 @@ type Config struct {
 	CallbackURL string
 
 	// PostLogoutRedirectURI is the URL where you get redirected after an
-	// RP initiated logut
+	// RP initiated logut. It defaults to the ExternalPostLogoutPath on the
+	// host of the callback URL.
 	PostLogoutRediretURI string
 
 	// BasePath is the path under which the other pathes get mapped
@@ -37,6 +41,10 @@ type Config struct {
 	// URL if available
 	LogoutPath string
 
+	// PostLogoutPath is the landing page after the RP initiated logout.
+	// The PostLogoutRediretURI points to this path by default.
+	PostLogoutPath string
+
 	// The external pathes are the paths under which the endpoint is
 	// reachable from externally. If not set this defaults to the internal
 	// path. These variables are only required if between the client and
@@ -49,8 +57,41 @@ type Config struct {
 	ExternalSessionInfoPath string
 	ExternalRefreshPath     string
 	ExternalLogoutPath      string
+	ExternalPostLogoutPath  string
 	// TODO: maybe only provide ExternalBasePath?
 
+	// BackChannelLogoutPath receives the logout tokens of the OpenID
+	// Connect Back-Channel Logout.
+	BackChannelLogoutPath         string
+	ExternalBackChannelLogoutPath string
+
+	// FrontChannelLogoutPath is embedded by the provider in an iframe to
+	// perform the OpenID Connect Front-Channel Logout. The browser only
+	// sends the session cookie to the iframe if the cookies are configured
+	// with SameSite=None and Secure. Otherwise the Front-Channel Logout is
+	// disabled.
+	FrontChannelLogoutPath         string
+	ExternalFrontChannelLogoutPath string
+
+	// ClientMetadataPath shows the client metadata (e.g. redirect_uris,
+	// logout URIs) which has to be registered at the providers.
+	ClientMetadataPath string
+
+	// HealthPath reports the readiness of the providers.
+	HealthPath string
+
+	// RevocationTTL specifies how long sessions terminated by a
+	// back-channel logout are remembered. This should be longer than the
+	// lifetime of a session. The revocations are kept in memory. Hence
+	// they are lost on a restart and are not shared between multiple
+	// instances unless a RevocationStore is set.
+	RevocationTTL time.Duration
+
+	// RevocationStore stores the sessions terminated by a back-channel
+	// logout. If not set the revocations are kept in memory for
+	// RevocationTTL.
+	RevocationStore RevocationStore
+
 	// DebugPath shows info about the current session
 	//
 	// Deprecated: will remove
@@ -62,6 +103,52 @@ type Config struct {
 	EncryptKey   []byte
 	CookieConfig CookieOptions
 
+	// SessionCookieName is the name of the cookie which stores the
+	// session. If the session does not fit into one cookie additional
+	// cookies with the suffix _N are used.
+	SessionCookieName string
+
+	// LoginStateCookieName is the name of the cookie which stores the
+	// state during the login.
+	LoginStateCookieName string
+
+	// LoginStateTTL is the duration after which a pending login expires.
+	LoginStateTTL time.Duration
+
+	// LastProviderCookieName is the name of the cookie which remembers the
+	// provider of the last login if multiple providers are configured.
+	// Returning users are sent to this provider without the provider
+	// selection.
+	LastProviderCookieName string
+
+	// LastProviderTTL is the duration for which the last provider is
+	// remembered. If it is zero the last provider is not remembered.
+	LastProviderTTL time.Duration
+
+	// CookiePrefix is prepended to the cookie names. This is used to set
+	// one of the cookie prefixes __Host- or __Secure-.
+	CookiePrefix string
+
+	// SessionBinding binds sessions to attributes of the client.
+	SessionBinding SessionBindingConfig
+
+	// SilentLogin tries to renew an expired session without a refresh
+	// token using an authentication request with prompt=none before an
+	// interactive login is initiated. It is only used for OpenID Connect
+	// providers. OAuth2 providers (e.g. github) always use an interactive
+	// login.
+	SilentLogin bool
+
+	// StepUp configures paths which require a stronger authentication
+	// (acr/amr) than the initial login.
+	StepUp StepUpConfig
+
+	// RedirectAllowedHosts and RedirectAllowedSchemes restrict the absolute
+	// URLs to which the user can be redirected after the login (see
+	// RedirectValidator).
+	RedirectAllowedHosts   []string
+	RedirectAllowedSchemes []string
+
 	// Used in templates
 	AppName string
 
@@ -74,12 +161,27 @@ func NewDefaultConfig() *Config {
 		BasePath:  "/auth",
 		LoginPath: "/login",
 		//CallbackPath:    "/callback",
-		SessionInfoPath: "/info",
-		RefreshPath:     "/refresh",
-		LogoutPath:      "/logout",
-		AppName:         "OIDC Proxy",
-		TemplateDevMode: false,
-		CookieConfig:    NewDefaultCookieOptions(),
+		SessionInfoPath:        "/info",
+		RefreshPath:            "/refresh",
+		LogoutPath:             "/logout",
+		PostLogoutPath:         "/post-logout",
+		BackChannelLogoutPath:  "/backchannel-logout",
+		FrontChannelLogoutPath: "/frontchannel-logout",
+		ClientMetadataPath:     "/client-metadata",
+		HealthPath:             "/healthz",
+		AppName:                "OIDC Proxy",
+		TemplateDevMode:        false,
+		CookieConfig:           NewDefaultCookieOptions(),
+		SessionBinding:         NewDefaultSessionBindingConfig(),
+		SilentLogin:            true,
+		RevocationTTL:          defaultRevocationTTL,
+
+		SessionCookieName:    "oprox",
+		LoginStateCookieName: "oprox_state",
+		LoginStateTTL:        defaultLoginStateTTL,
+
+		LastProviderCookieName: "oprox_provider",
+		LastProviderTTL:        defaultLastProviderTTL,
 	}
 }
 
@@ -115,13 +217,101 @@ func (c *Config) PrepareAndValidate() error {
 	c.LoginPath, c.ExternalLoginPath = preparePath(c.LoginPath, c.ExternalLoginPath, c.BasePath, c.ExternalBasePath)
 	// Logout
 	c.LogoutPath, c.ExternalLogoutPath = preparePath(c.LogoutPath, c.ExternalLogoutPath, c.BasePath, c.ExternalBasePath)
+	// Post Logout
+	c.PostLogoutPath, c.ExternalPostLogoutPath = preparePath(c.PostLogoutPath, c.ExternalPostLogoutPath, c.BasePath, c.ExternalBasePath)
 	// Refresh
 	c.RefreshPath, c.ExternalRefreshPath = preparePath(c.RefreshPath, c.ExternalRefreshPath, c.BasePath, c.ExternalBasePath)
 	// Info
 	c.SessionInfoPath, c.ExternalSessionInfoPath = preparePath(c.SessionInfoPath, c.ExternalSessionInfoPath, c.BasePath, c.ExternalBasePath)
+	// Back-Channel Logout
+	c.BackChannelLogoutPath, c.ExternalBackChannelLogoutPath = preparePath(c.BackChannelLogoutPath, c.ExternalBackChannelLogoutPath, c.BasePath, c.ExternalBasePath)
+	// Front-Channel Logout
+	if c.FrontChannelLogoutPath != "" && !c.CookieConfig.sentCrossSite() {
+		slog.Warn("front-channel logout disabled. it requires cookies with SameSite=None and Secure")
+		c.FrontChannelLogoutPath = ""
+		c.ExternalFrontChannelLogoutPath = ""
+	}
+	if c.FrontChannelLogoutPath != "" {
+		c.FrontChannelLogoutPath, c.ExternalFrontChannelLogoutPath = preparePath(c.FrontChannelLogoutPath, c.ExternalFrontChannelLogoutPath, c.BasePath, c.ExternalBasePath)
+	}
+	// Client Metadata
+	c.ClientMetadataPath, _ = preparePath(c.ClientMetadataPath, "", c.BasePath, "")
+
+	c.HealthPath, _ = preparePath(c.HealthPath, "", c.BasePath, "")
+
+	// Cookies
+	if c.CookiePrefix != "" && c.CookiePrefix != CookiePrefixHost && c.CookiePrefix != CookiePrefixSecure {
+		return fmt.Errorf("invalid cookie prefix '%s'. allowed prefixes are '%s' and '%s'", c.CookiePrefix, CookiePrefixHost, CookiePrefixSecure)
+	}
+	if !strings.HasPrefix(c.SessionCookieName, c.CookiePrefix) {
+		c.SessionCookieName = c.CookiePrefix + c.SessionCookieName
+	}
+	if !strings.HasPrefix(c.LoginStateCookieName, c.CookiePrefix) {
+		c.LoginStateCookieName = c.CookiePrefix + c.LoginStateCookieName
+	}
+	if !strings.HasPrefix(c.LastProviderCookieName, c.CookiePrefix) {
+		c.LastProviderCookieName = c.CookiePrefix + c.LastProviderCookieName
+	}
+	for _, name := range []string{c.SessionCookieName, c.LoginStateCookieName, c.LastProviderCookieName} {
+		err = c.CookieConfig.ValidateName(name)
+		if err != nil {
+			return err
+		}
+	}
+	if cookieNamesConflict(c.SessionCookieName, c.LoginStateCookieName) {
+		return fmt.Errorf("session cookie name '%s' conflicts with login state cookie name '%s'", c.SessionCookieName, c.LoginStateCookieName)
+	}
+	if cookieNamesConflict(c.SessionCookieName, c.LastProviderCookieName) {
+		return fmt.Errorf("session cookie name '%s' conflicts with last provider cookie name '%s'", c.SessionCookieName, c.LastProviderCookieName)
+	}
+	if cookieNamesConflict(c.LoginStateCookieName, c.LastProviderCookieName) {
+		return fmt.Errorf("login state cookie name '%s' conflicts with last provider cookie name '%s'", c.LoginStateCookieName, c.LastProviderCookieName)
+	}
+
+	if c.RevocationTTL <= 0 {
+		return fmt.Errorf("revocation ttl must be positive")
+	}
+
+	if c.LoginStateTTL <= 0 {
+		return fmt.Errorf("login state ttl must be positive")
+	}
+
+	if c.LastProviderTTL < 0 {
+		return fmt.Errorf("last provider ttl must not be negative")
+	}
+
+	err = c.SessionBinding.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid session binding: %w", err)
+	}
+
+	err = c.StepUp.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid step-up configuration: %w", err)
+	}
 	return nil
 }
 
+// postLogoutRedirectURI returns the PostLogoutRediretURI for a provider with
+// the callbackURL. If it is not configured the post logout landing page on the
+// host of the callback URL is used.
+func (c *Config) postLogoutRedirectURI(callbackURL string) string {
+	if c.PostLogoutRediretURI != "" {
+		return c.PostLogoutRediretURI
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.ResolveReference(&url.URL{Path: c.ExternalPostLogoutPath}).String()
+}
+
+// cookieNamesConflict reports whether the cookies a and b can not be
+// distinguished, also considering the parts of split cookies.
+func cookieNamesConflict(a, b string) bool {
+	return a == b || isCookiePart(a, b) || isCookiePart(b, a)
+}
+
 func preparePath(path string, externalPath string, base string, externalBase string) (resultingPath string, resultingExternalPath string) {
 	if base != "" {
 		path = pathpkg.Join(base, path)
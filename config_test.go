@@ -0,0 +1,55 @@
+package oidcproxy
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPostLogoutRedirectURI(t *testing.T) {
+	c := NewDefaultConfig()
+	c.CallbackURL = "https://app.example.com/auth/callback"
+	err := c.PrepareAndValidate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		configured  string
+		callbackURL string
+		expected    string
+	}{
+		{"", "https://app.example.com/auth/callback", "https://app.example.com/auth/post-logout"},
+		{"", "https://other.example.com:8443/cb?x=1", "https://other.example.com:8443/auth/post-logout"},
+		{"https://app.example.com/bye", "https://app.example.com/auth/callback", "https://app.example.com/bye"},
+	} {
+		c.PostLogoutRediretURI = test.configured
+		got := c.postLogoutRedirectURI(test.callbackURL)
+		if got != test.expected {
+			t.Errorf("%s: expected '%s', got '%s'", test.callbackURL, test.expected, got)
+		}
+	}
+}
+
+func TestFrontChannelLogoutCookieOptions(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		options  func(*CookieOptions)
+		expected string
+	}{
+		{"default cookie options", func(*CookieOptions) {}, ""},
+		{"SameSite=None", func(co *CookieOptions) { co.SameSite = http.SameSiteNoneMode }, "/auth/frontchannel-logout"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			c.CallbackURL = "https://app.example.com/auth/callback"
+			test.options(&c.CookieConfig)
+			err := c.PrepareAndValidate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.FrontChannelLogoutPath != test.expected || c.ExternalFrontChannelLogoutPath != test.expected {
+				t.Errorf("expected front-channel logout path '%s', got '%s' and '%s'", test.expected, c.FrontChannelLogoutPath, c.ExternalFrontChannelLogoutPath)
+			}
+		})
+	}
+}
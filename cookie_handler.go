@@ -1,7 +1,9 @@
 package oidcproxy
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/securecookie"
@@ -33,6 +35,81 @@ func (co *CookieOptions) NewCookie(name, value string) *http.Cookie {
 	}
 }
 
+// sentCrossSite reports whether the browser sends the cookies also in
+// cross-site requests (e.g. in an iframe embedded by the provider).
+func (co *CookieOptions) sentCrossSite() bool {
+	return co.SameSite == http.SameSiteNoneMode && co.Secure
+}
+
+const (
+	// CookiePrefixHost is the cookie name prefix which requires the cookie
+	// to be secure, to have the path / and no domain. See
+	// https://datatracker.ietf.org/doc/html/draft-ietf-httpbis-rfc6265bis#name-cookie-name-prefixes
+	CookiePrefixHost = "__Host-"
+	// CookiePrefixSecure is the cookie name prefix which requires the
+	// cookie to be secure.
+	CookiePrefixSecure = "__Secure-"
+)
+
+// ValidateName checks if a cookie with name can be set using the options co.
+// This is the case if the requirements of the cookie name prefixes are met.
+func (co *CookieOptions) ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("cookie name is empty")
+	}
+	if strings.ContainsAny(name, " \t\r\n;,=\"") {
+		return fmt.Errorf("invalid cookie name '%s'", name)
+	}
+	if co.SameSite == http.SameSiteNoneMode && !co.Secure {
+		return fmt.Errorf("cookie '%s' with SameSite=None must be secure", name)
+	}
+	if strings.HasPrefix(name, CookiePrefixSecure) && !co.Secure {
+		return fmt.Errorf("cookie '%s' with prefix %s must be secure", name, CookiePrefixSecure)
+	}
+	if strings.HasPrefix(name, CookiePrefixHost) {
+		if !co.Secure {
+			return fmt.Errorf("cookie '%s' with prefix %s must be secure", name, CookiePrefixHost)
+		}
+		if co.Path != "/" {
+			return fmt.Errorf("cookie '%s' with prefix %s must have path /", name, CookiePrefixHost)
+		}
+		if co.Domain != "" {
+			return fmt.Errorf("cookie '%s' with prefix %s must not have a domain", name, CookiePrefixHost)
+		}
+	}
+	return nil
+}
+
+// ParseSameSite parses the SameSite setting of a cookie (default, lax, strict
+// or none).
+func ParseSameSite(sameSite string) (http.SameSite, error) {
+	switch strings.ToLower(sameSite) {
+	case "", "default":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return http.SameSiteDefaultMode, fmt.Errorf("invalid SameSite value '%s'", sameSite)
+	}
+}
+
+func sameSiteString(sameSite http.SameSite) string {
+	switch sameSite {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	default:
+		return "default"
+	}
+}
+
 type CookieHandler struct {
 	securecookie  *securecookie.SecureCookie
 	cookieOptions CookieOptions
@@ -54,7 +131,7 @@ func NewCookieHandler(hashKey, encryptKey []byte) *CookieHandler {
 func NewCookieHandlerWithOptions(hashKey []byte, encryptKey []byte, options CookieOptions) *CookieHandler {
 	sc := securecookie.New(hashKey, encryptKey)
 	sc.MaxLength(0)
-	// sc.SetSerializer(newCompressSerializer())
+	sc.SetSerializer(newCompressSerializer())
 	return &CookieHandler{
 		securecookie:  sc,
 		cookieOptions: options,
@@ -0,0 +1,87 @@
+package oidcproxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/gorilla/securecookie"
+)
+
+// compressSerializerVersion1 is the prefix of values serialized by the
+// compressSerializer. Gob streams, which is what securecookie uses by default,
+// never start with a byte in the range 0x80-0xf7 because the first byte
+// always is either a small message length or a negated byte count. This
+// allows to tell the formats apart and still decode cookies which have been
+// created before the compression was introduced.
+const compressSerializerVersion1 byte = 0x81
+
+// compressSerializer serializes values as JSON and compresses them with
+// deflate. JSON is more compact than gob for the session struct (gob
+// transmits the type definition with every value) and the field names and the
+// JWT headers compress well.
+type compressSerializer struct {
+	level    int
+	fallback securecookie.Serializer
+}
+
+var _ securecookie.Serializer = (*compressSerializer)(nil)
+
+func newCompressSerializer() *compressSerializer {
+	return &compressSerializer{
+		level:    flate.BestCompression,
+		fallback: securecookie.GobEncoder{},
+	}
+}
+
+// Serialize implements securecookie.Serializer.
+func (cs *compressSerializer) Serialize(src any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(compressSerializerVersion1)
+
+	w, err := flate.NewWriter(buf, cs.level)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.NewEncoder(w).Encode(src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode value: %w", err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress value: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Deserialize implements securecookie.Serializer. Values without a known
+// version prefix are passed to the fallback serializer.
+func (cs *compressSerializer) Deserialize(src []byte, dst any) error {
+	if len(src) == 0 || src[0] != compressSerializerVersion1 {
+		return cs.fallback.Deserialize(src, dst)
+	}
+
+	r := flate.NewReader(bytes.NewReader(src[1:]))
+	defer r.Close()
+
+	// limit the decompressed size to protect against decompression bombs.
+	// a valid value can never be bigger than the cookies we accept.
+	const maxDecompressedSize = MAX_COOKIE_SIZE * MAX_COOKIE_COUNT * 4
+	data, err := io.ReadAll(io.LimitReader(r, maxDecompressedSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to decompress value: %w", err)
+	}
+	if len(data) > maxDecompressedSize {
+		return fmt.Errorf("decompressed value exceeds %d bytes", maxDecompressedSize)
+	}
+
+	err = json.Unmarshal(data, dst)
+	if err != nil {
+		return fmt.Errorf("failed to decode value: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package oidcproxy
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"golang.org/x/oauth2"
+)
+
+var (
+	testHashKey    = []byte("0123456789abcdef0123456789abcdef")
+	testEncryptKey = []byte("fedcba9876543210fedcba9876543210")
+)
+
+func TestCompressSerializerRoundTrip(t *testing.T) {
+	session := newTestSession()
+
+	cs := newCompressSerializer()
+	data, err := cs.Serialize(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != compressSerializerVersion1 {
+		t.Fatalf("missing version prefix: %x", data[0])
+	}
+
+	decoded := &Session{}
+	err = cs.Deserialize(data, decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(session.Tokens.AccessToken, decoded.Tokens.AccessToken) ||
+		!reflect.DeepEqual(session.User, decoded.User) ||
+		!session.Expiry.Equal(decoded.Expiry) {
+		t.Fatalf("session changed after round trip:\n got=%+v\nwant=%+v", decoded, session)
+	}
+}
+
+func TestCompressSerializerDecodesGob(t *testing.T) {
+	session := newTestSession()
+	session.User.Extra = nil
+
+	// cookie created before the compression got introduced
+	oldSC := securecookie.New(testHashKey, testEncryptKey)
+	oldSC.MaxLength(0)
+	encoded, err := oldSC.Encode("oprox", session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "oprox", Value: encoded})
+
+	ch := NewCookieHandler(testHashKey, testEncryptKey)
+	decoded := &Session{}
+	ok, err := ch.Get(req, "oprox", decoded)
+	if !ok || err != nil {
+		t.Fatalf("failed to decode gob cookie: ok=%t, err=%v", ok, err)
+	}
+	if decoded.Tokens.IDToken != session.Tokens.IDToken {
+		t.Fatal("id token changed")
+	}
+}
+
+func BenchmarkSessionSerializer(b *testing.B) {
+	session := newTestSession()
+	// gob can not encode interface values of unregistered types
+	session.User.Extra = nil
+
+	for _, bench := range []struct {
+		name       string
+		serializer securecookie.Serializer
+	}{
+		{
+			name:       "gob",
+			serializer: securecookie.GobEncoder{},
+		},
+		{
+			name:       "json",
+			serializer: securecookie.JSONEncoder{},
+		},
+		{
+			name:       "compress",
+			serializer: newCompressSerializer(),
+		},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			sc := securecookie.New(testHashKey, testEncryptKey)
+			sc.MaxLength(0)
+			sc.SetSerializer(bench.serializer)
+
+			var (
+				encoded string
+				err     error
+			)
+			for i := 0; i < b.N; i++ {
+				encoded, err = sc.Encode("oprox", session)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			cookieOptions := NewDefaultCookieOptions()
+			cookies := splitCookie(cookieOptions.NewCookie("oprox", encoded))
+			b.ReportMetric(float64(len(encoded)), "bytes/session")
+			b.ReportMetric(float64(len(cookies)), "cookies/session")
+		})
+	}
+}
+
+func newTestSession() *Session {
+	now := time.Now().Truncate(time.Second)
+	claims := map[string]any{
+		"iss":                "https://login.example.com/realms/example",
+		"sub":                "0b3f2f5e-4c1a-4d53-9a55-6c2d1a0e7f21",
+		"aud":                "oidc-proxy",
+		"azp":                "oidc-proxy",
+		"exp":                now.Add(time.Minute * 5).Unix(),
+		"iat":                now.Unix(),
+		"auth_time":          now.Unix(),
+		"sid":                "5d7b1b3c-7f0e-4b8e-a6a1-3f2c9d6b8e10",
+		"scope":              "openid email profile offline_access",
+		"email":              "jane.doe@example.com",
+		"email_verified":     true,
+		"name":               "Jane Doe",
+		"preferred_username": "jane.doe",
+		"given_name":         "Jane",
+		"family_name":        "Doe",
+		"groups":             []string{"admins", "developers", "operations", "support"},
+		"realm_access":       map[string]any{"roles": []string{"offline_access", "uma_authorization", "default-roles-example"}},
+	}
+
+	return &Session{
+		ProviderID: "Ab3dE5f",
+		Expiry:     now.Add(time.Minute * 5),
+		Tokens: &Tokens{
+			Token: oauth2.Token{
+				AccessToken:  newTestJWT(withClaims(claims, map[string]any{"typ": "Bearer", "jti": "a4c2e0b9-1f7d-4f3b-8c62-2b9e5d7a1c34"})),
+				TokenType:    "Bearer",
+				RefreshToken: newTestJWT(map[string]any{"iss": claims["iss"], "sub": claims["sub"], "typ": "Offline", "sid": claims["sid"]}),
+				Expiry:       now.Add(time.Minute * 5),
+			},
+			IDToken: newTestJWT(withClaims(claims, map[string]any{"typ": "ID", "at_hash": "pK3Yq0dN8w1sRkT7uVxZ2g"})),
+		},
+		User: &User{
+			ID:     "0b3f2f5e-4c1a-4d53-9a55-6c2d1a0e7f21",
+			Name:   "jane.doe@example.com",
+			Groups: []string{"admins", "developers", "operations", "support"},
+			Extra:  map[string]any{"department": "engineering"},
+		},
+	}
+}
+
+func withClaims(claims map[string]any, extra map[string]any) map[string]any {
+	result := map[string]any{}
+	for k, v := range claims {
+		result[k] = v
+	}
+	for k, v := range extra {
+		result[k] = v
+	}
+	return result
+}
+
+// newTestJWT returns a JWT with the given claims and a random RS256 sized
+// signature.
+func newTestJWT(claims map[string]any) string {
+	header := `{"alg":"RS256","typ":"JWT","kid":"k1Xq3Ozh2aNdX4mJ0e3q9r7Yq2mWZbqQw8LJxkTfRnU"}`
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		panic(err)
+	}
+	signature := make([]byte, 256)
+	_, err = rand.Read(signature)
+	if err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." +
+		base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -25,7 +26,7 @@ func SetCookie(w http.ResponseWriter, r *http.Request, c *http.Cookie) {
 	// remove no longer used cookies in the case where the new value is smaller
 OUTER:
 	for _, existingCookie := range r.Cookies() {
-		if !strings.HasPrefix(existingCookie.Name, c.Name+"_") {
+		if !isCookiePart(existingCookie.Name, c.Name) {
 			continue
 		}
 		for _, newCookie := range cs {
@@ -34,9 +35,14 @@ OUTER:
 				continue OUTER
 			}
 		}
-		// this cookie is no longer in use. delete it
-		delCookie := *existingCookie
+		// this cookie is no longer in use. delete it. we use the
+		// attributes of the new cookie because the browser does not
+		// send them and cookies with a prefix (e.g. __Host-) can only be
+		// deleted with the matching attributes.
+		delCookie := *c
+		delCookie.Name = existingCookie.Name
 		delCookie.Value = ""
+		delCookie.Expires = time.Time{}
 		delCookie.MaxAge = -1
 		http.SetCookie(w, &delCookie)
 	}
@@ -74,6 +80,21 @@ func getCookies(r *http.Request, name string) []*http.Cookie {
 	return cookies
 }
 
+// isCookiePart returns true if cookieName is the name of an additional cookie
+// (<name>_N) of a split cookie with the name name.
+func isCookiePart(cookieName, name string) bool {
+	suffix, ok := strings.CutPrefix(cookieName, name+"_")
+	if !ok || suffix == "" {
+		return false
+	}
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 // Concat all values of cookies.
 func concatCookieValues(cs []*http.Cookie) string {
 	var value strings.Builder
@@ -48,6 +48,88 @@ func TestRemoveOldCookies(t *testing.T) {
 	}
 }
 
+func TestRemoveOldCookiesWithPrefix(t *testing.T) {
+	u, err := url.Parse("https://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookieOptions := NewDefaultCookieOptions()
+	cookieName := CookiePrefixHost + "mytest"
+	err = cookieOptions.ValidateName(cookieName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// another cookie which shares the prefix of the cookie name
+	otherCookie := cookieOptions.NewCookie(cookieName+"_state", "state")
+
+	applyCookie(t, jar, u, func(w http.ResponseWriter, r *http.Request) {
+		SetCookie(w, r, otherCookie)
+		SetCookie(w, r, cookieOptions.NewCookie(cookieName, randData(4100)))
+	})
+
+	if len(jar.Cookies(u)) != 3 {
+		t.Fatal("expected three cookies")
+	}
+
+	var deletions []*http.Cookie
+	applyCookie(t, jar, u, func(w http.ResponseWriter, r *http.Request) {
+		SetCookie(w, r, cookieOptions.NewCookie(cookieName, "small value"))
+		deletions = w.(*httptest.ResponseRecorder).Result().Cookies()
+	})
+
+	if len(jar.Cookies(u)) != 2 {
+		t.Fatal("cookie not removed or other cookie removed")
+	}
+
+	deleted := 0
+	for _, c := range deletions {
+		if c.MaxAge >= 0 {
+			continue
+		}
+		deleted++
+		if c.Name != cookieName+"_0" {
+			t.Fatalf("unexpected deletion of cookie '%s'", c.Name)
+		}
+		if !c.Secure || c.Path != "/" {
+			t.Fatalf("deletion cookie does not satisfy the requirements of the %s prefix: %s", CookiePrefixHost, c)
+		}
+	}
+	if deleted != 1 {
+		t.Fatalf("expected one deleted cookie, got %d", deleted)
+	}
+}
+
+func TestValidateCookieName(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		options CookieOptions
+		valid   bool
+	}{
+		{"oprox", CookieOptions{}, true},
+		{"__Secure-oprox", CookieOptions{Secure: true}, true},
+		{"__Secure-oprox", CookieOptions{Secure: false}, false},
+		{"__Host-oprox", CookieOptions{Secure: true, Path: "/"}, true},
+		{"__Host-oprox", CookieOptions{Secure: true, Path: "/auth"}, false},
+		{"__Host-oprox", CookieOptions{Secure: true, Path: "/", Domain: "example.com"}, false},
+		{"oprox", CookieOptions{SameSite: http.SameSiteNoneMode}, false},
+		{"op;rox", CookieOptions{}, false},
+	} {
+		err := test.options.ValidateName(test.name)
+		if test.valid && err != nil {
+			t.Errorf("name=%s options=%+v: unexpected error: %s", test.name, test.options, err)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("name=%s options=%+v: expected error", test.name, test.options)
+		}
+	}
+}
+
 func TestSplit(t *testing.T) {
 
 	for i, test := range []struct {
@@ -0,0 +1,45 @@
+package oidcproxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+)
+
+// csrfTokenParameter is the form parameter which contains the CSRF token.
+const csrfTokenParameter = "csrf_token"
+
+// newCSRFKey derives the key of the CSRF tokens from the hash key so that
+// the tokens are not signed with the same key as the cookies.
+func newCSRFKey(hashKey []byte) []byte {
+	mac := hmac.New(sha256.New, hashKey)
+	mac.Write([]byte("csrf"))
+	return mac.Sum(nil)
+}
+
+// CSRFToken returns a token which protects state changing requests (e.g.
+// logout) of the session against cross-site request forgery. The token is
+// derived from attributes of the session which do not change on a refresh.
+func (sm *sessionManager) CSRFToken(s *Session) string {
+	mac := hmac.New(sha256.New, sm.csrfKey)
+	for _, value := range []string{
+		"csrf",
+		s.ProviderID,
+		strconv.FormatInt(s.Created.UnixNano(), 10),
+		s.Subject,
+		s.SID,
+	} {
+		mac.Write([]byte(value))
+		mac.Write([]byte{0})
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyCSRFToken returns true if token is a valid CSRF token for the session.
+func (sm *sessionManager) VerifyCSRFToken(s *Session, token string) bool {
+	if token == "" {
+		return false
+	}
+	return hmac.Equal([]byte(sm.CSRFToken(s)), []byte(token))
+}
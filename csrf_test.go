@@ -0,0 +1,62 @@
+package oidcproxy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCSRFToken(t *testing.T) {
+	ps, err := newProviderSet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashKey := bytes.Repeat([]byte{1}, 32)
+	sm, err := NewSessionManager(hashKey, nil, ps, NewDefaultCookieOptions(), NewDefaultSessionManagerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(sm.csrfKey, hashKey) {
+		t.Fatal("csrf key must not be the hash key")
+	}
+
+	created := time.Now()
+	session := &Session{ProviderID: "p1", Subject: "user1", SID: "sid1", Created: created}
+	token := sm.CSRFToken(session)
+	if token == "" {
+		t.Fatal("empty csrf token")
+	}
+
+	// the token stays valid if the session is refreshed
+	refreshed := *session
+	refreshed.Tokens = &Tokens{IDToken: "id-token"}
+	if !sm.VerifyCSRFToken(&refreshed, token) {
+		t.Fatal("valid token rejected")
+	}
+
+	for _, test := range []struct {
+		name    string
+		session *Session
+		token   string
+	}{
+		{"empty token", session, ""},
+		{"invalid token", session, token + "x"},
+		{"other provider", &Session{ProviderID: "p2", Subject: "user1", SID: "sid1", Created: created}, token},
+		{"other subject", &Session{ProviderID: "p1", Subject: "user2", SID: "sid1", Created: created}, token},
+		{"other sid", &Session{ProviderID: "p1", Subject: "user1", SID: "sid2", Created: created}, token},
+		{"new session", &Session{ProviderID: "p1", Subject: "user1", SID: "sid1", Created: created.Add(time.Second)}, token},
+	} {
+		if sm.VerifyCSRFToken(test.session, test.token) {
+			t.Errorf("%s: invalid token accepted", test.name)
+		}
+	}
+
+	// the token depends on the hash key
+	other, err := NewSessionManager(bytes.Repeat([]byte{2}, 32), nil, ps, NewDefaultCookieOptions(), NewDefaultSessionManagerOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.VerifyCSRFToken(session, token) {
+		t.Fatal("token of other key accepted")
+	}
+}
@@ -0,0 +1,340 @@
+package oidcproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrProviderNotReady is returned if an action on a provider is called
+// before the discovery of the provider succeeded.
+var ErrProviderNotReady = errors.New("provider not ready")
+
+// discoveryMinBackoff and discoveryMaxBackoff limit the delay between the
+// retries of a failed background discovery. They are only changed in tests.
+var (
+	discoveryMinBackoff = time.Second
+	discoveryMaxBackoff = time.Minute * 5
+)
+
+// providerState contains the settings of a provider which depend on the
+// discovery.
+type providerState struct {
+	// endpoints are the discovered endpoints merged with the explicitly
+	// configured endpoints.
+	endpoints Endpoints
+
+	oidcProvider *oidc.Provider
+	oauth2Config *oauth2.Config
+
+	// issuerMatcher verifies the issuer if the issuer check is skipped.
+	issuerMatcher *issuerMatcher
+
+	// oauth2HTTPClient is used for the requests of the oauth2 package and
+	// adds the client authentication if required.
+	oauth2HTTPClient *http.Client
+
+	tokenAuthMethod         ClientAuthMethod
+	revocationAuthMethod    ClientAuthMethod
+	introspectionAuthMethod ClientAuthMethod
+
+	// usePAR is set if pushed authorization requests are used.
+	usePAR bool
+
+	// parFallback is set if the parameters are sent in the URL if the
+	// pushed authorization request fails.
+	parFallback bool
+
+	// issuer is the audience of the request objects.
+	issuer string
+
+	// authorizationSigningAlgs are the algorithms with which the provider
+	// signs JWT secured authorization responses.
+	authorizationSigningAlgs []string
+}
+
+// currentState returns the state of the provider or ErrProviderNotReady if
+// the discovery has not succeeded yet.
+func (p *Provider) currentState() (*providerState, error) {
+	state := p.state.Load()
+	if state == nil {
+		return nil, ErrProviderNotReady
+	}
+	return state, nil
+}
+
+// startDiscovery runs the background discovery (if the discovery is
+// deferred) and the periodic rediscovery until ctx is done.
+func (p *Provider) startDiscovery(ctx context.Context) {
+	minBackoff, maxBackoff := discoveryMinBackoff, discoveryMaxBackoff
+	p.background.Add(1)
+	go func() {
+		defer p.background.Done()
+		if p.state.Load() == nil {
+			p.discoverInBackground(ctx, minBackoff, maxBackoff)
+		}
+		p.rediscoverPeriodically(ctx)
+	}()
+}
+
+// wait waits until the background discovery of the provider returned.
+func (p *Provider) wait() {
+	p.background.Wait()
+}
+
+// Ready returns true if the discovery of the provider succeeded.
+func (p *Provider) Ready() bool {
+	return p.state.Load() != nil
+}
+
+// DiscoveryError returns the error of the last failed discovery or nil if the
+// discovery succeeded.
+func (p *Provider) DiscoveryError() error {
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+	return p.discoveryErr
+}
+
+func (p *Provider) setDiscoveryError(err error) {
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+	p.discoveryErr = err
+}
+
+// discoverInBackground retries the discovery with an exponential backoff until
+// it succeeds or ctx is done.
+func (p *Provider) discoverInBackground(ctx context.Context, minBackoff, maxBackoff time.Duration) {
+	backoff := minBackoff
+	for {
+		state, err := p.discover(ctx)
+		if err == nil {
+			p.state.Store(state)
+			p.setDiscoveryError(nil)
+			slog.Info("provider discovery succeeded", "provider", p.String())
+			return
+		}
+
+		p.setDiscoveryError(err)
+		slog.Warn("provider discovery failed", "provider", p.String(), "retry_in", backoff, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// rediscoverPeriodically repeats the discovery in the configured interval
+// until ctx is done. On success the state of the provider is replaced. If the
+// discovery fails the previous state is kept.
+func (p *Provider) rediscoverPeriodically(ctx context.Context) {
+	interval := time.Duration(p.config.RediscoveryInterval)
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, err := p.discover(ctx)
+		if err != nil {
+			p.setDiscoveryError(err)
+			slog.Warn("provider rediscovery failed", "provider", p.String(), "err", err)
+			continue
+		}
+		p.setDiscoveryError(nil)
+
+		previous := p.state.Swap(state)
+		if previous != nil {
+			p.logStateChanges(previous, state)
+		}
+	}
+}
+
+// logStateChanges logs the endpoints and authentication methods which changed
+// between two discoveries.
+func (p *Provider) logStateChanges(previous, current *providerState) {
+	for _, change := range []struct {
+		name     string
+		previous string
+		current  string
+	}{
+		{"authorization_endpoint", previous.endpoints.AuthorizationEndpoint, current.endpoints.AuthorizationEndpoint},
+		{"token_endpoint", previous.endpoints.TokenEndpoint, current.endpoints.TokenEndpoint},
+		{"introspection_endpoint", previous.endpoints.IntrospectionEndpoint, current.endpoints.IntrospectionEndpoint},
+		{"userinfo_endpoint", previous.endpoints.UserinfoEndpoint, current.endpoints.UserinfoEndpoint},
+		{"end_session_endpoint", previous.endpoints.EndSessionEndpoint, current.endpoints.EndSessionEndpoint},
+		{"revocation_endpoint", previous.endpoints.RevocationEndpoint, current.endpoints.RevocationEndpoint},
+		{"pushed_authorization_request_endpoint", previous.endpoints.PushedAuthorizationRequestEndpoint, current.endpoints.PushedAuthorizationRequestEndpoint},
+		{"token_endpoint_auth_method", string(previous.tokenAuthMethod), string(current.tokenAuthMethod)},
+		{"revocation_endpoint_auth_method", string(previous.revocationAuthMethod), string(current.revocationAuthMethod)},
+		{"introspection_endpoint_auth_method", string(previous.introspectionAuthMethod), string(current.introspectionAuthMethod)},
+	} {
+		if change.previous == change.current {
+			continue
+		}
+		slog.Info("provider metadata changed", "provider", p.String(), "field", change.name, "previous", change.previous, "current", change.current)
+	}
+}
+
+// discover reads the provider metadata from the issuer and sets up the
+// endpoints, the client authentication and the oauth2 config.
+func (p *Provider) discover(ctx context.Context) (*providerState, error) {
+	var err error
+
+	// the oidc provider keeps the context to fetch the keys.
+	ctx = oidc.ClientContext(ctx, p.httpClient)
+
+	state := &providerState{}
+	metadata := &providerMetadata{}
+	endpoints := &Endpoints{}
+
+	discoveryCtx := ctx
+	if p.config.SkipIssuerCheck {
+		// the issuer of the tokens is verified using the issuerMatcher
+		discoveryCtx = oidc.InsecureIssuerURLContext(ctx, p.config.IssuerURL)
+	}
+
+	if p.config.IssuerURL != "" {
+		state.oidcProvider, err = oidc.NewProvider(discoveryCtx, p.config.IssuerURL)
+		if err != nil {
+			return nil, err
+		}
+		err := state.oidcProvider.Claims(endpoints)
+		if err != nil {
+			return nil, err
+		}
+		err = state.oidcProvider.Claims(metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state.tokenAuthMethod, err = p.selectClientAuthMethod(metadata.TokenEndpointAuthMethodsSupported)
+	if err != nil {
+		return nil, fmt.Errorf("token endpoint: %w", err)
+	}
+
+	state.revocationAuthMethod = state.tokenAuthMethod
+	if len(metadata.RevocationEndpointAuthMethodsSupported) > 0 {
+		state.revocationAuthMethod, err = p.selectClientAuthMethod(metadata.RevocationEndpointAuthMethodsSupported)
+		if err != nil {
+			return nil, fmt.Errorf("revocation endpoint: %w", err)
+		}
+	}
+
+	state.introspectionAuthMethod = state.tokenAuthMethod
+	if len(metadata.IntrospectionEndpointAuthMethodsSupported) > 0 {
+		state.introspectionAuthMethod, err = p.selectClientAuthMethod(metadata.IntrospectionEndpointAuthMethodsSupported)
+		if err != nil {
+			return nil, fmt.Errorf("introspection endpoint: %w", err)
+		}
+	}
+
+	if p.config.SkipIssuerCheck {
+		template := p.config.IssuerTemplate
+		if template == "" {
+			template = metadata.Issuer
+		}
+		state.issuerMatcher, err = newIssuerMatcher(template, p.config.AllowedTenants)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.usesMTLS(state.tokenAuthMethod) {
+		endpoints.setMTLSAliases(&metadata.MTLSEndpointAliases)
+	}
+
+	// apply explicitly set settings which take precedence over the
+	// discoverd endpoints
+	state.endpoints = p.config.Endpoints
+	state.endpoints.Merge(endpoints)
+
+	if state.endpoints.AuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("authorization endpoint not set")
+	}
+	if state.endpoints.TokenEndpoint == "" {
+		return nil, fmt.Errorf("token endpoint not set")
+	}
+
+	state.usePAR, err = p.usePAR(&state.endpoints, metadata)
+	state.parFallback = p.parFallback(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	state.issuer = metadata.Issuer
+	if state.issuer == "" {
+		state.issuer = p.config.IssuerURL
+	}
+	state.authorizationSigningAlgs = metadata.AuthorizationSigningAlgValuesSupported
+
+	if p.config.RequestObject {
+		_, err := p.requestObjectSigner()
+		if err != nil {
+			return nil, fmt.Errorf("request object: %w", err)
+		}
+	}
+
+	// check the client assertion settings early instead of failing on
+	// the first request
+	for _, method := range []ClientAuthMethod{state.tokenAuthMethod, state.revocationAuthMethod, state.introspectionAuthMethod} {
+		if !method.usesClientAssertion() {
+			continue
+		}
+		_, err := p.clientAssertionSigner(method)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	state.oauth2Config = &oauth2.Config{
+		ClientID:    p.config.ClientID,
+		Scopes:      p.config.Scopes,
+		RedirectURL: p.config.CallbackURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:   state.endpoints.AuthorizationEndpoint,
+			TokenURL:  state.endpoints.TokenEndpoint,
+			AuthStyle: oauth2AuthStyle(state.tokenAuthMethod),
+		},
+	}
+	if state.tokenAuthMethod.usesClientSecret() {
+		state.oauth2Config.ClientSecret = p.config.ClientSecret
+	}
+
+	// the oauth2 package does not support client assertions. we add them
+	// in the transport.
+	state.oauth2HTTPClient = p.httpClient
+	if state.tokenAuthMethod.usesClientAssertion() {
+		state.oauth2Config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+
+		transport := p.httpClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		oauth2HTTPClient := *p.httpClient
+		oauth2HTTPClient.Transport = &clientAssertionTransport{
+			base:     transport,
+			provider: p,
+			state:    state,
+		}
+		state.oauth2HTTPClient = &oauth2HTTPClient
+	}
+
+	return state, nil
+}
@@ -0,0 +1,219 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// discoveryServer serves the provider metadata. The number of requests in
+// failures fail before the metadata is returned. The token endpoint can be
+// changed with setTokenEndpoint.
+type discoveryServer struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	failures      int
+	tokenEndpoint string
+	requests      []time.Time
+}
+
+func newDiscoveryServer(t *testing.T, failures int) *discoveryServer {
+	ds := &discoveryServer{failures: failures}
+	ds.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ds.mu.Lock()
+		defer ds.mu.Unlock()
+		ds.requests = append(ds.requests, time.Now())
+		if len(ds.requests) <= ds.failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 ds.URL,
+			"authorization_endpoint": ds.URL + "/authorize",
+			"token_endpoint":         ds.tokenEndpoint,
+			"jwks_uri":               ds.URL + "/keys",
+		})
+	}))
+	ds.tokenEndpoint = ds.URL + "/token"
+	t.Cleanup(ds.Close)
+	return ds
+}
+
+func (ds *discoveryServer) setTokenEndpoint(tokenEndpoint string) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.tokenEndpoint = tokenEndpoint
+}
+
+func (ds *discoveryServer) requestTimes() []time.Time {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return append([]time.Time{}, ds.requests...)
+}
+
+// setDiscoveryBackoff shortens the backoff of the background discovery of the
+// providers created in the test.
+func setDiscoveryBackoff(t *testing.T, minBackoff, maxBackoff time.Duration) {
+	previousMin, previousMax := discoveryMinBackoff, discoveryMaxBackoff
+	discoveryMinBackoff, discoveryMaxBackoff = minBackoff, maxBackoff
+	t.Cleanup(func() {
+		discoveryMinBackoff, discoveryMaxBackoff = previousMin, previousMax
+	})
+}
+
+// waitFor polls condition until it is true or the timeout is reached.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestDeferredDiscovery(t *testing.T) {
+	ds := newDiscoveryServer(t, 3)
+
+	setDiscoveryBackoff(t, time.Millisecond*20, time.Millisecond*40)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := NewProvider(ctx, ProviderConfig{
+		IssuerURL:           ds.URL,
+		ClientID:            "client",
+		ClientSecret:        "secret",
+		HTTPClient:          ds.Client(),
+		DeferDiscovery:      true,
+		RediscoveryInterval: Duration(time.Millisecond * 20),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the provider is not ready until the discovery succeeds
+	if p.Ready() {
+		t.Fatal("provider ready before discovery")
+	}
+	_, err = p.currentState()
+	if err != ErrProviderNotReady {
+		t.Fatalf("expected ErrProviderNotReady, got %v", err)
+	}
+
+	waitFor(t, p.Ready)
+	if p.DiscoveryError() != nil {
+		t.Fatalf("discovery error not reset: %v", p.DiscoveryError())
+	}
+
+	// the retries back off exponentially up to the max backoff
+	requests := ds.requestTimes()
+	if len(requests) < 4 {
+		t.Fatalf("expected 4 discovery requests, got %d", len(requests))
+	}
+	for i, minDelay := range []time.Duration{time.Millisecond * 20, time.Millisecond * 40, time.Millisecond * 40} {
+		if delay := requests[i+1].Sub(requests[i]); delay < minDelay {
+			t.Errorf("retry %d after %s, expected at least %s", i+1, delay, minDelay)
+		}
+	}
+
+	state, err := p.currentState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.endpoints.TokenEndpoint != ds.URL+"/token" {
+		t.Fatalf("unexpected token endpoint %s", state.endpoints.TokenEndpoint)
+	}
+
+	// the state is swapped if the rediscovery returns other endpoints
+	ds.setTokenEndpoint(ds.URL + "/token2")
+	waitFor(t, func() bool {
+		state, err := p.currentState()
+		return err == nil && state.endpoints.TokenEndpoint == ds.URL+"/token2"
+	})
+
+	// the rediscovery stops if the context is cancelled
+	cancel()
+	p.wait()
+	time.Sleep(time.Millisecond * 20)
+	count := len(ds.requestTimes())
+	time.Sleep(time.Millisecond * 100)
+	if len(ds.requestTimes()) != count {
+		t.Fatal("rediscovery continued after cancel")
+	}
+}
+
+func TestRediscoveryKeepsStateOnFailure(t *testing.T) {
+	ds := newDiscoveryServer(t, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := NewProvider(ctx, ProviderConfig{
+		IssuerURL:           ds.URL,
+		ClientID:            "client",
+		ClientSecret:        "secret",
+		HTTPClient:          ds.Client(),
+		RediscoveryInterval: Duration(time.Millisecond * 20),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Ready() {
+		t.Fatal("provider not ready after discovery")
+	}
+	state, _ := p.currentState()
+
+	// fail all further discoveries
+	ds.mu.Lock()
+	ds.failures = len(ds.requests) + 1000
+	ds.mu.Unlock()
+
+	waitFor(t, func() bool { return p.DiscoveryError() != nil })
+	current, err := p.currentState()
+	if err != nil || current != state {
+		t.Fatalf("expected previous state to be kept, got %v (err=%v)", current, err)
+	}
+}
+
+func TestProviderSetClose(t *testing.T) {
+	ds := newDiscoveryServer(t, 1000)
+
+	setDiscoveryBackoff(t, time.Millisecond*10, time.Millisecond*10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewProvider(ctx, ProviderConfig{
+		IssuerURL:      ds.URL,
+		ClientID:       "client",
+		ClientSecret:   "secret",
+		HTTPClient:     ds.Client(),
+		DeferDiscovery: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, err := newProviderSet(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps.cancel = cancel
+
+	waitFor(t, func() bool { return len(ds.requestTimes()) >= 2 })
+
+	// Close returns after the background discovery stopped. a request
+	// which was cancelled by Close may still arrive at the server.
+	ps.Close()
+	time.Sleep(time.Millisecond * 20)
+	count := len(ds.requestTimes())
+	time.Sleep(time.Millisecond * 100)
+	if len(ds.requestTimes()) != count {
+		t.Fatal("discovery continued after close")
+	}
+	if p.Ready() {
+		t.Fatal("provider ready without successful discovery")
+	}
+}
@@ -0,0 +1,47 @@
+package oidcproxy
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// FrontChannelLogoutHandler implements the OpenID Connect Front-Channel
+// Logout. The provider renders the handler in an iframe with the parameters
+// iss and sid. See
+// https://openid.net/specs/openid-connect-frontchannel-1_0.html.
+// The request is not authenticated. Hence the session is only removed if the
+// session cookie is sent and its provider and sid match iss and sid. Browsers
+// only send cookies to cross-site iframes if they are set with SameSite=None.
+func FrontChannelLogoutHandler(sm *sessionManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response must not be cached. since the handler is
+		// embedded in an iframe we must not set X-Frame-Options.
+		w.Header().Set("Cache-Control", "no-cache, no-store")
+		w.Header().Set("Pragma", "no-cache")
+
+		issuer := r.URL.Query().Get("iss")
+		sid := r.URL.Query().Get("sid")
+		if issuer == "" || sid == "" {
+			http.Error(w, "iss or sid missing", http.StatusBadRequest)
+			return
+		}
+
+		s := &Session{}
+		ok, err := sm.cookieHandler.Get(r, sm.sessionCookieName, s)
+		if !ok || err != nil {
+			slog.Info("front-channel logout without session", "iss", issuer, "sid", sid)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		provider, err := sm.providerSet.GetByID(s.ProviderID)
+		if err != nil || s.SID != sid || !provider.IssuerMatches(issuer) {
+			slog.Info("front-channel logout does not match session", "iss", issuer, "sid", sid)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		slog.Info("front-channel logout", "provider", provider.String(), "sid", sid)
+		sm.RemoveSession(w, r)
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -0,0 +1,45 @@
+package oidcproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFrontChannelLogoutHandler(t *testing.T) {
+	p := &Provider{id: "p1", config: &ProviderConfig{IssuerURL: testIssuer}}
+	sm := newTestSessionManager(t, NewDefaultSessionManagerOptions(), p)
+	handler := FrontChannelLogoutHandler(sm)
+
+	cookies := sessionCookies(t, sm, &Session{ProviderID: p.ID(), Subject: "user1", SID: "sid1", Created: time.Now()})
+
+	for _, test := range []struct {
+		name    string
+		url     string
+		cookies []*http.Cookie
+		code    int
+		removed bool
+	}{
+		{"sid missing", "/auth/frontchannel-logout?iss=" + testIssuer, cookies, http.StatusBadRequest, false},
+		{"no session", "/auth/frontchannel-logout?iss=" + testIssuer + "&sid=sid1", nil, http.StatusOK, false},
+		{"unknown sid", "/auth/frontchannel-logout?iss=" + testIssuer + "&sid=sid2", cookies, http.StatusOK, false},
+		{"other issuer", "/auth/frontchannel-logout?iss=https://other.example.com&sid=sid1", cookies, http.StatusOK, false},
+		{"matching session", "/auth/frontchannel-logout?iss=" + testIssuer + "&sid=sid1", cookies, http.StatusOK, true},
+	} {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequestWithCookies(test.url, test.cookies))
+		if recorder.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, recorder.Code)
+		}
+		removed := len(recorder.Result().Cookies()) > 0
+		if removed != test.removed {
+			t.Errorf("%s: expected removed=%t, got %t", test.name, test.removed, removed)
+		}
+	}
+
+	// sids of the unauthenticated requests are not remembered
+	if entries := sm.revocations.(*revocationList).entries; len(entries) != 0 {
+		t.Fatalf("expected no revocations, got %v", entries)
+	}
+}
@@ -4,13 +4,13 @@ go 1.21
 
 require (
 	github.com/coreos/go-oidc/v3 v3.6.0
+	github.com/go-jose/go-jose/v3 v3.0.0
 	github.com/gorilla/securecookie v1.1.1
 	golang.org/x/oauth2 v0.12.0
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/go-jose/go-jose/v3 v3.0.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	golang.org/x/crypto v0.13.0 // indirect
 	google.golang.org/appengine v1.6.8 // indirect
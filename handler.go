@@ -1,8 +1,12 @@
 package oidcproxy
 
 import (
+	"errors"
 	"log/slog"
 	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
 )
 
 // LoadSessionHandler loads the session and makes it available in the context
@@ -27,20 +31,38 @@ func RemoveSessionHandler(sm *sessionManager) http.Handler {
 // the login endpoint.
 // If a session is available but it is no longer valid and if the session
 // contains a refresh_token it tries to obtain a new session using the refresh
-// token.
+// token. Otherwise it initiates a silent login (prompt=none) at the provider
+// of the session if enabled and supported by the provider.
+// If the path requires a step-up authentication (see StepUpConfig) which the
+// session does not satisfy it initiates a new login at the provider of the
+// session.
 func AuthenticateHandler(sm *sessionManager, loginEndpoint string, next http.Handler) http.Handler {
-	redirectToLogin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	redirectToLogin := func(w http.ResponseWriter, r *http.Request) {
 		if !(r.Method == "GET" || r.Method == "HEAD") {
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
-		originURI := r.URL.RequestURI()
-		sm.SetLoginState(w, r, &LoginState{URI: originURI})
-		http.Redirect(w, r, loginEndpoint, http.StatusSeeOther)
-	})
+		parameters := url.Values{}
+		parameters.Set(returnURLParameter, r.URL.RequestURI())
+		http.Redirect(w, r, loginEndpoint+"?"+parameters.Encode(), http.StatusSeeOther)
+	}
+
+	// without a refresh token try to obtain a new session without user
+	// interaction (prompt=none) first
+	renewSession := func(w http.ResponseWriter, r *http.Request, provider *Provider) {
+		if sm.silentLogin && provider.SupportsSilentLogin() {
+			reauthenticate(w, r, sm, provider, &LoginState{Silent: true}, oauth2.SetAuthURLParam("prompt", "none"))
+			return
+		}
+		redirectToLogin(w, r)
+	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		currentSession, _ := sm.GetSession(w, r)
+		currentSession, err := sm.GetSession(w, r)
+		if errors.Is(err, ErrSessionBindingMismatch) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
 		if currentSession == nil {
 			slog.Debug("no session available: initiate login")
 			redirectToLogin(w, r)
@@ -50,15 +72,15 @@ func AuthenticateHandler(sm *sessionManager, loginEndpoint string, next http.Han
 		// run silent refresh or redirect to login if session expired
 		if !currentSession.Valid() {
 			if !currentSession.HasRefreshToken() {
-				slog.Debug("no refresh token available: initiate login")
-				redirectToLogin(w, r)
+				slog.Debug("no refresh token available: initiate login", "silent", sm.silentLogin)
+				renewSession(w, r, currentSession.Provider)
 				return
 			}
 
 			newSession, err := currentSession.Provider.Refresh(r.Context(), currentSession.Session)
 			if err != nil {
-				slog.Info("token refresh failed. initiate login", "err", err)
-				redirectToLogin(w, r)
+				slog.Info("token refresh failed. initiate login", "err", err, "silent", sm.silentLogin)
+				renewSession(w, r, currentSession.Provider)
 				return
 			}
 
@@ -73,6 +95,12 @@ func AuthenticateHandler(sm *sessionManager, loginEndpoint string, next http.Han
 			currentSession.Session = newSession
 		}
 
+		if route := sm.stepUp.route(r.URL.Path); route != nil && !sm.stepUp.satisfied(route, currentSession.Session) {
+			slog.Debug("insufficient authentication: initiate step-up login", "path_prefix", route.PathPrefix, "acr", currentSession.ACR, "amr", currentSession.AMR)
+			reauthenticate(w, r, sm, currentSession.Provider, sm.stepUp.loginState(route), oauth2.SetAuthURLParam("prompt", "login"))
+			return
+		}
+
 		r = r.WithContext(ContextWithSession(r.Context(), currentSession))
 		next.ServeHTTP(w, r)
 	})
@@ -109,33 +137,84 @@ func RefreshHandler(sm *sessionManager, postRefreshHandler http.Handler, errorHa
 
 // LogoutHandler deletes the session cookies, revokes the token (if supportd by
 // the provider) and redirects to the end_session_uri of the provider (if
-// supported by the provider).
-func LogoutHandler(sm *sessionManager, postLogoutHandler http.Handler) http.Handler {
+// supported by the provider). The provider of the last login is forgotten so
+// that the next login shows the provider selection again.
+// To protect against cross-site request forgery the logout is only performed
+// on a POST request with a valid CSRF token. On a GET request a confirmation
+// page is shown which performs the POST request.
+// The redirect to the end_session_uri contains a state which is verified by
+// the PostLogoutHandler when the provider redirects back.
+func LogoutHandler(sm *sessionManager, tm *templateManager, postLogoutHandler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// remove session (delete cookie)
-		sm.RemoveSession(w, r)
-
 		session, _ := sm.GetSession(w, r)
 		if session == nil {
 			postLogoutHandler.ServeHTTP(w, r)
 			return
 		}
 
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			w.Header().Add("Cache-Control", "no-cache")
+			tm.servePage(w, "logout_confirm", &LogoutTemplateData{
+				Session:   session.Session,
+				Provider:  session.Provider,
+				CSRFToken: sm.CSRFToken(session.Session),
+			})
+			return
+		case http.MethodPost:
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !sm.VerifyCSRFToken(session.Session, r.PostFormValue(csrfTokenParameter)) {
+			slog.Info("logout with invalid csrf token")
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+
 		// revoke tokens
-		if session.HasRefreshToken() {
-			err := session.Provider.Revoke(r.Context(), session.RefreshToken())
-			if err != nil && err != ErrNotSupported {
-				slog.Warn("failed to revoke token", "err", err)
+		err := session.Provider.RevokeSession(r.Context(), session.Session)
+		if err != nil && err != ErrNotSupported {
+			slog.Warn("failed to revoke token", "err", err)
+		}
+
+		// remove session (delete cookie)
+		sm.RemoveSession(w, r)
+		sm.RemoveLastProvider(w, r)
+
+		// the state is only sent back by the provider if a
+		// post_logout_redirect_uri is configured
+		var state string
+		if session.Provider.config.PostLogoutRedirectURI != "" {
+			const STATE_LENGTH = 10
+			state, err = randString(STATE_LENGTH)
+			if err != nil {
+				slog.Error("faild to generate random state", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
 			}
 		}
 
 		// redirect to end session endpoint if available
-		endSessionURL, err := session.Provider.EndSessionEndpoint(r.Context(), session.Session)
+		endSessionURL, err := session.Provider.EndSessionEndpoint(r.Context(), session.Session, state)
 		if err != nil && err != ErrNotSupported {
 			slog.Warn("failed to obtain end session endpoint", "err", err)
 		}
 
 		if err == nil {
+			if state != "" {
+				err = sm.SetLoginState(w, r, &LoginState{
+					ProviderID: session.ProviderID,
+					State:      state,
+					Logout:     true,
+				})
+				if err != nil {
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
 			http.Redirect(w, r, endSessionURL, http.StatusSeeOther)
 			return
 		}
@@ -143,3 +222,27 @@ func LogoutHandler(sm *sessionManager, postLogoutHandler http.Handler) http.Hand
 		postLogoutHandler.ServeHTTP(w, r)
 	})
 }
+
+type LogoutTemplateData struct {
+	Session   *Session
+	Provider  *Provider
+	CSRFToken string
+}
+
+// PostLogoutHandler handles the redirect from the provider after the RP
+// initiated logout. It verifies the state of the logout and then calls next.
+// Redirects with an unknown or expired state are rejected.
+func PostLogoutHandler(sm *sessionManager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := r.URL.Query().Get("state")
+		logoutState := sm.GetLoginState(w, r, state)
+		if state == "" || logoutState == nil || !logoutState.Logout {
+			slog.Info("post logout redirect with unknown state", "state", state)
+			http.Error(w, "invalid state", http.StatusBadRequest)
+			return
+		}
+		sm.DeleteLoginState(w, r, state)
+		slog.Info("logout completed", "provider_id", logoutState.ProviderID)
+		next.ServeHTTP(w, r)
+	})
+}
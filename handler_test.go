@@ -0,0 +1,188 @@
+package oidcproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostLogoutHandler(t *testing.T) {
+	sm := newTestSessionManager(t, NewDefaultSessionManagerOptions())
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	err := sm.SetLoginState(recorder, r, &LoginState{ProviderID: "p1", State: "logout1", Logout: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddCookie(recorder.Result().Cookies()[0])
+	recorder = httptest.NewRecorder()
+	err = sm.SetLoginState(recorder, r, &LoginState{ProviderID: "p1", State: "login1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginStateCookie := recorder.Result().Cookies()[0]
+
+	handler := PostLogoutHandler(sm, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, test := range []struct {
+		url  string
+		code int
+	}{
+		{"/auth/post-logout", http.StatusBadRequest},
+		{"/auth/post-logout?state=unknown", http.StatusBadRequest},
+		{"/auth/post-logout?state=login1", http.StatusBadRequest},
+		{"/auth/post-logout?state=logout1", http.StatusNoContent},
+	} {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		r.AddCookie(loginStateCookie)
+		handler.ServeHTTP(recorder, r)
+		if recorder.Code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.url, test.code, recorder.Code)
+		}
+	}
+}
+
+func TestLogoutHandlerState(t *testing.T) {
+	for _, test := range []struct {
+		name                  string
+		postLogoutRedirectURI string
+		loginState            bool
+	}{
+		{"post logout redirect uri", "https://app.example.com/auth/post-logout", true},
+		{"no post logout redirect uri", "", false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := &Provider{id: "p1", config: &ProviderConfig{ClientID: "client", PostLogoutRedirectURI: test.postLogoutRedirectURI}}
+			p.state.Store(&providerState{
+				endpoints: Endpoints{EndSessionEndpoint: "https://idp.example.com/logout"},
+			})
+			sm := newTestSessionManager(t, NewDefaultSessionManagerOptions(), p)
+			session := &Session{ProviderID: "p1", Expiry: time.Now().Add(time.Hour)}
+
+			form := url.Values{csrfTokenParameter: {sm.CSRFToken(session)}}
+			r := httptest.NewRequest(http.MethodPost, "/auth/logout", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			for _, c := range sessionCookies(t, sm, session) {
+				r.AddCookie(c)
+			}
+			recorder := httptest.NewRecorder()
+			LogoutHandler(sm, nil, http.NotFoundHandler()).ServeHTTP(recorder, r)
+
+			if recorder.Code != http.StatusSeeOther {
+				t.Fatalf("expected %d, got %d", http.StatusSeeOther, recorder.Code)
+			}
+			location, err := url.Parse(recorder.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := location.Query().Get("state")
+			if (state != "") != test.loginState {
+				t.Errorf("unexpected state '%s' in %s", state, location)
+			}
+			var loginStateCookie bool
+			for _, c := range recorder.Result().Cookies() {
+				if c.Name == sm.loginStateCookieName && c.MaxAge >= 0 {
+					loginStateCookie = true
+				}
+			}
+			if loginStateCookie != test.loginState {
+				t.Errorf("expected login state cookie %t, got %t", test.loginState, loginStateCookie)
+			}
+		})
+	}
+}
+
+func TestAuthenticateHandlerStepUp(t *testing.T) {
+	provider := newTestAuthProvider(&ProviderConfig{})
+	options := NewDefaultSessionManagerOptions()
+	err := options.StepUp.SetRoutes("/admin=acr:mfa,/admin=amr:hwk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm := newTestSessionManager(t, options, provider)
+
+	cookies := sessionCookies(t, sm, &Session{
+		ProviderID: provider.ID(),
+		Created:    time.Now(),
+		Expiry:     time.Now().Add(time.Hour),
+		ACR:        "pwd",
+	})
+
+	handler := AuthenticateHandler(sm, "/auth/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	serve := func(target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequestWithCookies(target, cookies))
+		return recorder
+	}
+
+	if recorder := serve("/administrator"); recorder.Code != http.StatusNoContent {
+		t.Fatalf("expected no step-up for other path, got %d", recorder.Code)
+	}
+
+	// the step-up redirects directly to the provider and keeps the
+	// requirements in the login state
+	recorder := serve("/admin/users?id=1")
+	if recorder.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect, got %d", recorder.Code)
+	}
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	if location.Host != "idp.example.com" || query.Get("prompt") != "login" || query.Get("acr_values") != "mfa" {
+		t.Fatalf("unexpected redirect to %s", location)
+	}
+
+	r := newRequestWithCookies("/", recorder.Result().Cookies())
+	loginState := sm.GetLoginState(httptest.NewRecorder(), r, query.Get("state"))
+	if loginState == nil || loginState.URI != "/admin/users?id=1" || loginState.ACRValues != "mfa" || len(loginState.AMR) != 1 || loginState.AMR[0] != "hwk" {
+		t.Fatalf("unexpected login state %+v", loginState)
+	}
+}
+
+func TestAuthenticateHandlerSilentLogin(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		issuerURL string
+		silent    bool
+	}{
+		{"openid connect", "https://idp.example.com", true},
+		{"oauth2", "", false},
+	} {
+		provider := newTestAuthProvider(&ProviderConfig{IssuerURL: test.issuerURL})
+		options := NewDefaultSessionManagerOptions()
+		options.SilentLogin = true
+		sm := newTestSessionManager(t, options, provider)
+
+		// expired session without refresh token
+		cookies := sessionCookies(t, sm, &Session{
+			ProviderID: provider.ID(),
+			Created:    time.Now().Add(-time.Hour * 2),
+			Expiry:     time.Now().Add(-time.Hour),
+		})
+
+		handler := AuthenticateHandler(sm, "/auth/login", http.NotFoundHandler())
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequestWithCookies("/app?prompt=login", cookies))
+
+		location, err := url.Parse(recorder.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.silent && (location.Host != "idp.example.com" || location.Query().Get("prompt") != "none") {
+			t.Errorf("%s: expected silent login, got redirect to %s", test.name, location)
+		}
+		if !test.silent && (location.Path != "/auth/login" || location.Query().Get("rd") != "/app?prompt=login") {
+			t.Errorf("%s: expected login, got redirect to %s", test.name, location)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package oidcproxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// ProviderHealth is the readiness of a provider.
+type ProviderHealth struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthHandler reports the readiness of the providers. A provider is ready
+// as soon as its discovery succeeded. If a provider is not ready the handler
+// responds with status 503.
+func HealthHandler(providers []*Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		health := struct {
+			Ready     bool             `json:"ready"`
+			Providers []ProviderHealth `json:"providers"`
+		}{
+			Ready:     true,
+			Providers: []ProviderHealth{},
+		}
+
+		for _, p := range providers {
+			providerHealth := ProviderHealth{
+				ID:    p.ID(),
+				Name:  p.config.Name,
+				Ready: p.Ready(),
+			}
+			if err := p.DiscoveryError(); err != nil && !providerHealth.Ready {
+				providerHealth.Error = err.Error()
+			}
+			if !providerHealth.Ready {
+				health.Ready = false
+				status = http.StatusServiceUnavailable
+			}
+			health.Providers = append(health.Providers, providerHealth)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		err := json.NewEncoder(w).Encode(health)
+		if err != nil {
+			slog.Info("failed to encode health", "err", err)
+		}
+	})
+}
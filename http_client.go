@@ -0,0 +1,182 @@
+package oidcproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// defaultHTTPTimeout is the timeout of the requests to the provider if no
+// timeout is configured.
+const defaultHTTPTimeout = time.Second * 30
+
+// HTTPClientConfig configures the HTTP client which a provider uses for the
+// requests to the identity provider.
+type HTTPClientConfig struct {
+	// Timeout of the requests. Defaults to 30s.
+	Timeout Duration `json:"timeout,omitempty"`
+
+	// ProxyURL is the URL of the HTTP proxy. If not set the proxy is
+	// read from the environment (HTTPS_PROXY, NO_PROXY).
+	ProxyURL string `json:"proxy_url,omitempty"`
+
+	// Headers are added to each request.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// CAFile is a PEM bundle of certificate authorities which are trusted
+	// in addition to the system certificate authorities.
+	CAFile string `json:"ca_file,omitempty"`
+
+	// TLSServerName overwrites the server name which is used to verify
+	// the certificate of the provider.
+	TLSServerName string `json:"tls_server_name,omitempty"`
+
+	// TLSMinVersion is the minimum TLS version (1.2 or 1.3).
+	TLSMinVersion string `json:"tls_min_version,omitempty"`
+
+	// TLSInsecureSkipVerify disables the verification of the provider
+	// certificate. Use this for testing only.
+	TLSInsecureSkipVerify bool `json:"tls_insecure_skip_verify,omitempty"`
+
+	// TLSClientCert is presented to the identity provider. This is
+	// required for the mutual-TLS client authentication and certificate
+	// bound access tokens (see https://www.rfc-editor.org/rfc/rfc8705). If
+	// not set the certificate is read from TLSClientCertFile and
+	// TLSClientKeyFile.
+	TLSClientCert     *tls.Certificate `json:"-"`
+	TLSClientCertFile string           `json:"tls_client_cert_file,omitempty"`
+	TLSClientKeyFile  string           `json:"tls_client_key_file,omitempty"`
+}
+
+func (c *HTTPClientConfig) loadClientCert() error {
+	if c.TLSClientCert != nil || c.TLSClientCertFile == "" && c.TLSClientKeyFile == "" {
+		return nil
+	}
+	if c.TLSClientCertFile == "" || c.TLSClientKeyFile == "" {
+		return fmt.Errorf("tls client certificate requires a certificate and a key file")
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSClientCertFile, c.TLSClientKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load tls client certificate: %w", err)
+	}
+	c.TLSClientCert = &cert
+	return nil
+}
+
+func (c *HTTPClientConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.TLSServerName,
+		InsecureSkipVerify: c.TLSInsecureSkipVerify,
+	}
+
+	switch c.TLSMinVersion {
+	case "":
+	case "1.2":
+		tlsConfig.MinVersion = tls.VersionTLS12
+	case "1.3":
+		tlsConfig.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("unsupported tls min version '%s'", c.TLSMinVersion)
+	}
+
+	if c.CAFile != "" {
+		rawCAs, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ca file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(rawCAs) {
+			return nil, fmt.Errorf("no certificates found in ca file '%s'", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.TLSClientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*c.TLSClientCert}
+	}
+	return tlsConfig, nil
+}
+
+// NewClient returns an HTTP client based on the configuration.
+func (c *HTTPClientConfig) NewClient() (*http.Client, error) {
+	err := c.loadClientCert()
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig, err = c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.ProxyURL != "" {
+		proxyURL, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	timeout := time.Duration(c.Timeout)
+	if timeout == 0 {
+		timeout = defaultHTTPTimeout
+	}
+
+	var roundTripper http.RoundTripper = transport
+	if len(c.Headers) > 0 {
+		roundTripper = &headerTransport{
+			base:    transport,
+			headers: c.Headers,
+		}
+	}
+
+	return &http.Client{
+		Transport: roundTripper,
+		Timeout:   timeout,
+	}, nil
+}
+
+// headerTransport adds headers to each request.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// Duration is a time.Duration which is represented as string (e.g. 1m30s) in
+// JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string (e.g. 30s): %w", err)
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
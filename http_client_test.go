@@ -0,0 +1,163 @@
+package oidcproxy
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPClientConfigNewClient(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidCAFile := filepath.Join(dir, "invalid.pem")
+	err = os.WriteFile(invalidCAFile, []byte("no pem"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		config  HTTPClientConfig
+		timeout time.Duration
+		proxy   string
+		err     bool
+	}{
+		{"default", HTTPClientConfig{}, defaultHTTPTimeout, "", false},
+		{"timeout", HTTPClientConfig{Timeout: Duration(time.Second * 5)}, time.Second * 5, "", false},
+		{"proxy", HTTPClientConfig{ProxyURL: "http://proxy.example.com:3128"}, defaultHTTPTimeout, "http://proxy.example.com:3128", false},
+		{"ca file", HTTPClientConfig{CAFile: caFile}, defaultHTTPTimeout, "", false},
+		{"headers", HTTPClientConfig{Headers: map[string]string{"X-Test": "value"}}, defaultHTTPTimeout, "", false},
+		{"invalid proxy", HTTPClientConfig{ProxyURL: "://proxy"}, 0, "", true},
+		{"missing ca file", HTTPClientConfig{CAFile: filepath.Join(dir, "missing.pem")}, 0, "", true},
+		{"invalid ca file", HTTPClientConfig{CAFile: invalidCAFile}, 0, "", true},
+		{"invalid tls min version", HTTPClientConfig{TLSMinVersion: "1.1"}, 0, "", true},
+		{"client cert without key", HTTPClientConfig{TLSClientCertFile: "cert.pem"}, 0, "", true},
+	} {
+		client, err := test.config.NewClient()
+		if test.err != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if client.Timeout != test.timeout {
+			t.Errorf("%s: got timeout %s, want %s", test.name, client.Timeout, test.timeout)
+		}
+
+		transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			transport = client.Transport.(*headerTransport).base.(*http.Transport)
+		}
+		if test.proxy != "" {
+			proxy, err := transport.Proxy(httptest.NewRequest(http.MethodGet, "https://idp.example.com", nil))
+			if err != nil || proxy == nil || proxy.String() != test.proxy {
+				t.Errorf("%s: got proxy %v (err=%v), want %s", test.name, proxy, err, test.proxy)
+			}
+		}
+	}
+}
+
+func TestHTTPClientConfigCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		config HTTPClientConfig
+		err    bool
+	}{
+		{"system cas", HTTPClientConfig{}, true},
+		{"ca file", HTTPClientConfig{CAFile: caFile}, false},
+	} {
+		client, err := test.config.NewClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if test.err != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
+
+func TestHTTPClientConfigHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo", r.Header.Get("X-Test"))
+	}))
+	defer server.Close()
+
+	client, err := (&HTTPClientConfig{Headers: map[string]string{"X-Test": "value"}}).NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Echo") != "value" {
+		t.Errorf("header not sent, got '%s'", resp.Header.Get("X-Echo"))
+	}
+	// the request of the caller is not modified
+	if req.Header.Get("X-Test") != "" {
+		t.Error("header added to the request of the caller")
+	}
+}
+
+func TestDuration(t *testing.T) {
+	data, err := json.Marshal(Duration(time.Second * 90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"1m30s"` {
+		t.Errorf("got %s, want \"1m30s\"", data)
+	}
+
+	for _, test := range []struct {
+		input    string
+		expected Duration
+		err      bool
+	}{
+		{`"30s"`, Duration(time.Second * 30), false},
+		{`"1m30s"`, Duration(time.Second * 90), false},
+		{`30`, 0, true},
+		{`"30"`, 0, true},
+		{`"thirty seconds"`, 0, true},
+	} {
+		var d Duration
+		err := json.Unmarshal([]byte(test.input), &d)
+		if test.err != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", test.input, err)
+		}
+		if d != test.expected {
+			t.Errorf("%s: got %s, want %s", test.input, time.Duration(d), time.Duration(test.expected))
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package oidcproxy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// tenantPlaceholder is the placeholder of the tenant in an issuer template
+// (e.g. https://login.microsoftonline.com/{tenantid}/v2.0).
+const tenantPlaceholder = "{tenantid}"
+
+// anyTenant in AllowedTenants allows all tenants.
+const anyTenant = "*"
+
+// issuerMatcher verifies the issuer of multi-tenant providers against an
+// issuer template and a list of allowed tenants.
+type issuerMatcher struct {
+	template       string
+	pattern        *regexp.Regexp
+	allowedTenants []string
+}
+
+func newIssuerMatcher(template string, allowedTenants []string) (*issuerMatcher, error) {
+	if template == "" {
+		return nil, fmt.Errorf("issuer template missing")
+	}
+	if len(allowedTenants) == 0 {
+		return nil, fmt.Errorf("allowed tenants missing. use '%s' to allow all tenants", anyTenant)
+	}
+
+	pattern := regexp.QuoteMeta(template)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta(tenantPlaceholder), "([^/]+)", 1)
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer template '%s': %w", template, err)
+	}
+
+	return &issuerMatcher{
+		template:       template,
+		pattern:        re,
+		allowedTenants: allowedTenants,
+	}, nil
+}
+
+// match returns the tenant of the issuer or an error if the issuer does not
+// match the template or the tenant is not allowed.
+func (m *issuerMatcher) match(issuer string) (string, error) {
+	matches := m.pattern.FindStringSubmatch(issuer)
+	if matches == nil {
+		return "", fmt.Errorf("issuer '%s' does not match template '%s'", issuer, m.template)
+	}
+
+	// template without placeholder
+	if len(matches) < 2 {
+		return "", nil
+	}
+
+	tenant := matches[1]
+	if !slices.Contains(m.allowedTenants, anyTenant) && !slices.Contains(m.allowedTenants, tenant) {
+		return "", fmt.Errorf("tenant '%s' is not allowed", tenant)
+	}
+	return tenant, nil
+}
+
+// IssuerMatches returns true if issuer is a valid issuer of the provider. For
+// providers with SkipIssuerCheck the issuer is verified against the issuer
+// template and the allowed tenants.
+func (p *Provider) IssuerMatches(issuer string) bool {
+	if !p.config.SkipIssuerCheck {
+		return strings.TrimSuffix(p.config.IssuerURL, "/") == strings.TrimSuffix(issuer, "/")
+	}
+
+	state, err := p.currentState()
+	if err != nil || state.issuerMatcher == nil {
+		return false
+	}
+	_, err = state.issuerMatcher.match(issuer)
+	return err == nil
+}
+
+// verifyToken verifies a JWT issued by the provider (e.g. id_token, logout
+// token). For providers with SkipIssuerCheck the issuer is verified against the
+// issuer template and the allowed tenants instead of the issuer URL.
+func (p *Provider) verifyToken(ctx context.Context, state *providerState, config oidc.Config, rawToken string) (*oidc.IDToken, error) {
+	if state.oidcProvider == nil {
+		return nil, fmt.Errorf("verifier not configured")
+	}
+
+	if !p.config.SkipIssuerCheck {
+		return state.oidcProvider.VerifierContext(ctx, &config).Verify(ctx, rawToken)
+	}
+
+	config.SkipIssuerCheck = true
+	token, err := state.oidcProvider.VerifierContext(ctx, &config).Verify(ctx, rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tenant, err := state.issuerMatcher.match(token.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	// azure additionally sets the tenant in the tid claim
+	claims := struct {
+		TenantID string `json:"tid"`
+	}{}
+	_ = token.Claims(&claims)
+	if tenant != "" && claims.TenantID != "" && claims.TenantID != tenant {
+		return nil, fmt.Errorf("tenant '%s' of the issuer does not match tid '%s'", tenant, claims.TenantID)
+	}
+	return token, nil
+}
@@ -0,0 +1,81 @@
+package oidcproxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+func TestIssuerMatcher(t *testing.T) {
+	const template = "https://login.microsoftonline.com/{tenantid}/v2.0"
+
+	for _, test := range []struct {
+		allowedTenants []string
+		issuer         string
+		tenant         string
+		err            bool
+	}{
+		{[]string{"tenant-a"}, "https://login.microsoftonline.com/tenant-a/v2.0", "tenant-a", false},
+		{[]string{"tenant-a"}, "https://login.microsoftonline.com/tenant-b/v2.0", "", true},
+		{[]string{"*"}, "https://login.microsoftonline.com/tenant-b/v2.0", "tenant-b", false},
+		{[]string{"*"}, "https://login.microsoftonline.com/tenant-b/v2.0/", "", true},
+		{[]string{"*"}, "https://login.microsoftonline.com/a/b/v2.0", "", true},
+		{[]string{"*"}, "https://evil.example.com/tenant-b/v2.0", "", true},
+	} {
+		matcher, err := newIssuerMatcher(template, test.allowedTenants)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tenant, err := matcher.match(test.issuer)
+		if test.err != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", test.issuer, err)
+		}
+		if tenant != test.tenant {
+			t.Errorf("%s: got tenant '%s', want '%s'", test.issuer, tenant, test.tenant)
+		}
+	}
+
+	_, err := newIssuerMatcher(template, nil)
+	if err == nil {
+		t.Error("expected error without allowed tenants")
+	}
+}
+
+func TestVerifyTokenTenant(t *testing.T) {
+	const template = "https://login.microsoftonline.com/{tenantid}/v2.0"
+	p, sign := newTestTokenProvider(t, &ProviderConfig{ClientID: "client", SkipIssuerCheck: true})
+	matcher, err := newIssuerMatcher(template, []string{"tenant-a", "tenant-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := p.currentState()
+	state.issuerMatcher = matcher
+
+	for _, test := range []struct {
+		issuer   string
+		tenantID string
+		valid    bool
+	}{
+		{"https://login.microsoftonline.com/tenant-a/v2.0", "tenant-a", true},
+		{"https://login.microsoftonline.com/tenant-a/v2.0", "", true},
+		{"https://login.microsoftonline.com/tenant-a/v2.0", "tenant-b", false},
+		{"https://login.microsoftonline.com/tenant-c/v2.0", "tenant-c", false},
+		{"https://evil.example.com/tenant-a/v2.0", "tenant-a", false},
+	} {
+		claims := map[string]any{
+			"iss": test.issuer,
+			"aud": "client",
+			"sub": "user1",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		if test.tenantID != "" {
+			claims["tid"] = test.tenantID
+		}
+		_, err := p.verifyToken(context.Background(), state, oidc.Config{ClientID: "client"}, sign(claims))
+		if (err == nil) != test.valid {
+			t.Errorf("%s (tid=%s): got err=%v, want valid=%t", test.issuer, test.tenantID, err, test.valid)
+		}
+	}
+}
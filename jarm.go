@@ -0,0 +1,158 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	jose "github.com/go-jose/go-jose/v3"
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+)
+
+// The response modes query and form_post (see
+// https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html) and the
+// response modes of JWT Secured Authorization Responses (JARM) (see
+// https://openid.net/specs/oauth-v2-jarm.html#name-response-mode-jwt).
+const (
+	ResponseModeQuery       = "query"
+	ResponseModeFormPost    = "form_post"
+	ResponseModeJWT         = "jwt"
+	ResponseModeQueryJWT    = "query.jwt"
+	ResponseModeFormPostJWT = "form_post.jwt"
+)
+
+// requestObjectLifetime is the lifetime of signed request objects.
+const requestObjectLifetime = time.Minute * 5
+
+// responseModes are the supported values of ProviderConfig.ResponseMode.
+var responseModes = []string{"", ResponseModeQuery, ResponseModeFormPost, ResponseModeJWT, ResponseModeQueryJWT, ResponseModeFormPostJWT}
+
+func validateResponseMode(responseMode string) error {
+	if !slices.Contains(responseModes, responseMode) {
+		return fmt.Errorf("unsupported response mode '%s'", responseMode)
+	}
+	return nil
+}
+
+func isJARMResponseMode(responseMode string) bool {
+	return responseMode == ResponseModeJWT || responseMode == ResponseModeQueryJWT || responseMode == ResponseModeFormPostJWT
+}
+
+// requestObjectSigner returns the signer for the request objects. The request
+// objects are signed with the client key if available and otherwise with the
+// client secret.
+func (p *Provider) requestObjectSigner() (jose.Signer, error) {
+	method := ClientSecretJWT
+	if p.config.ClientKey != nil {
+		method = PrivateKeyJWT
+	}
+	return p.jwtSigner(method, "oauth-authz-req+jwt")
+}
+
+// requestObject returns the authorization parameters as signed request
+// object. See https://www.rfc-editor.org/rfc/rfc9101. The parameters
+// client_id, response_type and scope are kept outside of the request object
+// as required by OpenID Connect.
+func (p *Provider) requestObject(state *providerState, parameters url.Values) (url.Values, error) {
+	signer, err := p.requestObjectSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := randString(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	claims := map[string]any{}
+	for name := range parameters {
+		claims[name], err = requestObjectClaim(name, parameters.Get(name))
+		if err != nil {
+			return nil, err
+		}
+	}
+	registeredClaims := josejwt.Claims{
+		Issuer:    p.config.ClientID,
+		Audience:  josejwt.Audience{state.issuer},
+		ID:        id,
+		IssuedAt:  josejwt.NewNumericDate(now),
+		NotBefore: josejwt.NewNumericDate(now),
+		Expiry:    josejwt.NewNumericDate(now.Add(requestObjectLifetime)),
+	}
+	requestObject, err := josejwt.Signed(signer).Claims(claims).Claims(registeredClaims).CompactSerialize()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign request object: %w", err)
+	}
+
+	result := url.Values{}
+	for _, name := range []string{"client_id", "response_type", "scope"} {
+		if parameters.Has(name) {
+			result.Set(name, parameters.Get(name))
+		}
+	}
+	result.Set("request", requestObject)
+	return result, nil
+}
+
+// requestObjectClaim returns the claim of an authorization parameter in the
+// request object. The parameters are strings except max_age which is a number
+// and claims which is a JSON object (see
+// https://openid.net/specs/openid-connect-core-1_0.html#RequestObject).
+func requestObjectClaim(name, value string) (any, error) {
+	switch name {
+	case "max_age":
+		maxAge, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_age '%s'", value)
+		}
+		return maxAge, nil
+	case "claims":
+		claims := map[string]any{}
+		err := json.Unmarshal([]byte(value), &claims)
+		if err != nil {
+			return nil, fmt.Errorf("invalid claims parameter: %w", err)
+		}
+		return claims, nil
+	default:
+		return value, nil
+	}
+}
+
+// DecodeAuthorizationResponse verifies the JWT of a JWT Secured
+// Authorization Response (JARM) against the keys of the provider and returns
+// the contained authorization response parameters (e.g. code and state).
+// Encrypted responses are not supported.
+func (p *Provider) DecodeAuthorizationResponse(ctx context.Context, response string) (url.Values, error) {
+	state, err := p.currentState()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = p.clientContext(ctx, state)
+	token, err := p.verifyToken(ctx, state, oidc.Config{
+		ClientID:             p.config.ClientID,
+		SupportedSigningAlgs: state.authorizationSigningAlgs,
+	}, response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify authorization response: %w", err)
+	}
+
+	claims := map[string]any{}
+	err = token.Claims(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	parameters := url.Values{}
+	for _, name := range []string{"code", "state", "error", "error_description", "error_uri"} {
+		if value, ok := claims[name].(string); ok {
+			parameters.Set(name, value)
+		}
+	}
+	return parameters, nil
+}
@@ -0,0 +1,128 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	jose "github.com/go-jose/go-jose/v3"
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+)
+
+func TestDecodeAuthorizationResponse(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "k1", Algorithm: "RS256", Use: "sig"},
+		}})
+	}))
+	defer server.Close()
+
+	const issuer = "https://idp.example.com"
+	p := &Provider{
+		config:     &ProviderConfig{ClientID: "client"},
+		httpClient: server.Client(),
+	}
+	p.state.Store(&providerState{
+		oidcProvider: (&oidc.ProviderConfig{IssuerURL: issuer, JWKSURL: server.URL}).NewProvider(context.Background()),
+	})
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: "k1"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(audience string) string {
+		response, err := josejwt.Signed(signer).Claims(map[string]any{
+			"iss":   issuer,
+			"aud":   audience,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"code":  "code1",
+			"state": "state1",
+		}).CompactSerialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	parameters, err := p.DecodeAuthorizationResponse(context.Background(), sign("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parameters.Get("code") != "code1" || parameters.Get("state") != "state1" {
+		t.Errorf("unexpected parameters: %v", parameters)
+	}
+
+	_, err = p.DecodeAuthorizationResponse(context.Background(), sign("other-client"))
+	if err == nil {
+		t.Error("expected error for response of other client")
+	}
+}
+
+func TestRequestObject(t *testing.T) {
+	p := &Provider{config: &ProviderConfig{
+		ClientID:     "client",
+		ClientSecret: "a-secret-with-at-least-32-bytes!",
+	}}
+	state := &providerState{issuer: "https://idp.example.com"}
+
+	parameters, err := p.requestObject(state, url.Values{
+		"client_id":     {"client"},
+		"response_type": {"code"},
+		"scope":         {"openid"},
+		"state":         {"state1"},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"max_age":       {"300"},
+		"claims":        {`{"id_token":{"acr":{"essential":true}}}`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parameters.Has("state") || parameters.Get("client_id") != "client" || parameters.Get("scope") != "openid" {
+		t.Errorf("unexpected parameters: %v", parameters)
+	}
+
+	token, err := josejwt.ParseSigned(parameters.Get("request"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ := token.Headers[0].ExtraHeaders[jose.HeaderType]; typ != "oauth-authz-req+jwt" {
+		t.Errorf("unexpected typ %v", typ)
+	}
+	claims := struct {
+		josejwt.Claims
+		State       string         `json:"state"`
+		RedirectURI string         `json:"redirect_uri"`
+		MaxAge      int            `json:"max_age"`
+		ClaimsParam map[string]any `json:"claims"`
+	}{}
+	err = token.Claims([]byte(p.config.ClientSecret), &claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = claims.Validate(josejwt.Expected{Issuer: "client", Audience: josejwt.Audience{"https://idp.example.com"}, Time: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.State != "state1" || claims.RedirectURI != "https://app.example.com/callback" || claims.MaxAge != 300 || claims.ClaimsParam["id_token"] == nil {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	_, err = p.requestObject(state, url.Values{"claims": {"invalid"}})
+	if err == nil {
+		t.Error("expected error for invalid claims parameter")
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"log/slog"
 	"net/http"
 	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
 )
 
 // LoginHandler returns a handler which sets a state and then redirects the
 // request to the authorization endpoint of the provider. If multiple providers
 // are configured the provider is selected via the query paramter
-// provider=<providerID>.  If multiple providers are configured
+// provider=<providerID>. If it is not set the provider is selected
+// automatically (see selectProvider). If no provider could be selected
 // providerSelectionHandler can be used to render a provider selection dialog.
-func LoginHandler(sm *sessionManager, providerSelectionHandler http.Handler) http.Handler {
+// The query parameter rd specifies where to redirect after the login. It is
+// only accepted if it is allowed by the redirectValidator.
+func LoginHandler(sm *sessionManager, redirectValidator *RedirectValidator, providerSelectionHandler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		providerID := r.URL.Query().Get("provider")
 
@@ -23,6 +29,18 @@ func LoginHandler(sm *sessionManager, providerSelectionHandler http.Handler) htt
 			providerID = providers[0].ID()
 		}
 
+		var loginHint string
+		if providerID == "" {
+			provider, reason := selectProvider(sm, redirectValidator, r)
+			if provider != nil {
+				slog.Debug("provider selected automatically", "provider", provider.String(), "reason", reason)
+				providerID = provider.ID()
+				if reason == "email" {
+					loginHint = r.URL.Query().Get("email")
+				}
+			}
+		}
+
 		if providerID == "" {
 			if providerSelectionHandler == nil {
 				http.Error(w, "provider not set", http.StatusBadRequest)
@@ -47,35 +65,169 @@ func LoginHandler(sm *sessionManager, providerSelectionHandler http.Handler) htt
 			return
 		}
 
-		state := sm.GetLoginState(w, r)
-		if state == nil {
-			state = &LoginState{
-				ProviderID: providerID,
-				State:      stateStr,
-			}
-		} else {
-			state.ProviderID = providerID
-			state.State = stateStr
+		parameters := provider.passthroughParameters(r.URL.Query())
+		if loginHint != "" {
+			parameters.Set("login_hint", loginHint)
 		}
 
-		err = sm.SetLoginState(w, r, state)
+		maxAge, err := provider.maxAge(parameters)
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			slog.Info("invalid authorization parameter", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		redirectURL, err := provider.AuthorizationEndpoint(r.Context(), state.State)
-		if err != nil {
-			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
-			return
+		state := &LoginState{
+			ProviderID: providerID,
+			State:      stateStr,
+			MaxAge:     maxAge,
+			Parameters: parameters,
+		}
+
+		if returnURL := r.URL.Query().Get(returnURLParameter); returnURL != "" {
+			if redirectValidator.Valid(returnURL) {
+				state.URI = returnURL
+			} else {
+				slog.Warn("redirect target not allowed", "uri", returnURL)
+			}
 		}
-		slog.Debug("redirect for authentication", "url", redirectURL)
-		http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+
+		startLogin(w, r, sm, provider, state, state.authCodeOpts()...)
 	})
 }
 
+// startLogin stores the login state and redirects to the authorization
+// endpoint of the provider.
+func startLogin(w http.ResponseWriter, r *http.Request, sm *sessionManager, provider *Provider, state *LoginState, opts ...oauth2.AuthCodeOption) {
+	err := sm.SetLoginState(w, r, state)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	redirectToProvider(w, r, provider, state, opts...)
+}
+
+// restartLogin restarts the login of state with a new state (e.g. an
+// interactive login after a failed silent login). The previous state is
+// removed so that the authorization response can not be used twice.
+func restartLogin(w http.ResponseWriter, r *http.Request, sm *sessionManager, provider *Provider, state *LoginState) {
+	const STATE_LENGTH = 10
+	stateStr, err := randString(STATE_LENGTH)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		slog.Error("faild to generate random state", "err", err)
+		return
+	}
+
+	restarted := *state
+	restarted.State = stateStr
+	restarted.Created = time.Time{}
+	restarted.Silent = false
+	err = sm.ReplaceLoginState(w, r, state.State, &restarted)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	redirectToProvider(w, r, provider, &restarted, restarted.authCodeOpts()...)
+}
+
+// redirectToProvider redirects to the authorization endpoint of the provider.
+func redirectToProvider(w http.ResponseWriter, r *http.Request, provider *Provider, state *LoginState, opts ...oauth2.AuthCodeOption) {
+	redirectURL, err := provider.AuthorizationEndpoint(r.Context(), state.State, opts...)
+	if err != nil {
+		slog.Warn("failed to get authorization endpoint", "provider", provider.String(), "err", err)
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	slog.Debug("redirect for authentication", "url", redirectURL)
+	http.Redirect(w, r, redirectURL, http.StatusSeeOther)
+}
+
+// reauthenticate starts a new login for the user of an existing session at
+// provider (e.g. a silent login or a step-up authentication). Unlike LoginHandler the
+// authorization parameters are not taken from the request. After the login the
+// user is redirected back to the current request.
+func reauthenticate(w http.ResponseWriter, r *http.Request, sm *sessionManager, provider *Provider, state *LoginState, opts ...oauth2.AuthCodeOption) {
+	if !(r.Method == "GET" || r.Method == "HEAD") {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	const STATE_LENGTH = 10
+	stateStr, err := randString(STATE_LENGTH)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		slog.Error("faild to generate random state", "err", err)
+		return
+	}
+
+	maxAge, err := provider.maxAge(url.Values{})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		slog.Error("invalid authorization parameter", "err", err)
+		return
+	}
+
+	state.ProviderID = provider.ID()
+	state.State = stateStr
+	state.URI = r.URL.RequestURI()
+	state.MaxAge = maxAge
+	startLogin(w, r, sm, provider, state, append(state.authCodeOpts(), opts...)...)
+}
+
+// selectProviderParameter is the query parameter of the login endpoint which
+// skips the automatic provider selection and always shows the provider
+// selection (e.g. to switch to another provider).
+const selectProviderParameter = "select_provider"
+
+// selectProvider selects the provider of a login request without an explicit
+// provider. It tries in order:
+//   - the provider parameter of the URL to which is redirected after the login (rd)
+//   - the domain of the email address of the query parameter email (home realm discovery)
+//   - the host of the request
+//   - the provider of the last login
+//
+// It returns nil if no provider matches or the selection was requested
+// explicitly. The reason describes by which criteria the provider was
+// selected. The provider parameter of rd is only used if rd is allowed by the
+// redirectValidator.
+func selectProvider(sm *sessionManager, redirectValidator *RedirectValidator, r *http.Request) (provider *Provider, reason string) {
+	query := r.URL.Query()
+	if query.Has(selectProviderParameter) {
+		return nil, ""
+	}
+
+	if returnURL := query.Get(returnURLParameter); redirectValidator.Valid(returnURL) {
+		// Valid ensures that returnURL can be parsed
+		u, _ := url.Parse(returnURL)
+		if hint := u.Query().Get("provider"); hint != "" {
+			provider, err := sm.providerSet.GetByID(hint)
+			if err == nil {
+				return provider, "hint"
+			}
+		}
+	}
+
+	if email := query.Get("email"); email != "" {
+		// do not fall back to other criteria if the user entered an email
+		// address so that the selection shows that there is no match.
+		provider, _ := sm.providerSet.GetByEmail(email)
+		return provider, "email"
+	}
+
+	if provider, ok := sm.providerSet.GetByHost(r.Host); ok {
+		return provider, "host"
+	}
+
+	if provider := sm.GetLastProvider(r); provider != nil {
+		return provider, "last_provider"
+	}
+	return nil, ""
+}
+
 // ProviderSelectionHandler returns a handler which shows a provider selection
-// dialog.
+// dialog. If a provider has configured email domains the dialog additionally
+// asks for the email address to select the provider by its domain.
 // TODO: public?
 func ProviderSelectionHandler(appName string, providers []*Provider, tm *templateManager) http.Handler {
 	type LoginProviderData struct {
@@ -83,26 +235,58 @@ func ProviderSelectionHandler(appName string, providers []*Provider, tm *templat
 		Href string
 	}
 
+	type HiddenParameter struct {
+		Name  string
+		Value string
+	}
+
 	loginProviderData := struct {
-		Name      string
-		Providers []LoginProviderData
+		Name             string
+		Providers        []LoginProviderData
+		EmailDiscovery   bool
+		Email            string
+		EmailNotFound    bool
+		HiddenParameters []HiddenParameter
 	}{
 		Name: appName,
 	}
 
 	for _, provider := range providers {
-		parameters := url.Values{}
-		parameters.Add("provider", provider.ID())
-
-		loginProviderData.Providers = append(loginProviderData.Providers, LoginProviderData{
-			Name: provider.config.Name,
-			Href: "?" + parameters.Encode(),
-		})
+		if len(provider.config.EmailDomains) > 0 {
+			loginProviderData.EmailDiscovery = true
+		}
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := loginProviderData
+		for _, provider := range providers {
+			// keep the other parameters (e.g. rd)
+			parameters := r.URL.Query()
+			parameters.Set("provider", provider.ID())
+			parameters.Del("email")
+			parameters.Del(selectProviderParameter)
+
+			data.Providers = append(data.Providers, LoginProviderData{
+				Name: provider.config.Name,
+				Href: "?" + parameters.Encode(),
+			})
+		}
+
+		if data.EmailDiscovery {
+			data.Email = r.URL.Query().Get("email")
+			data.EmailNotFound = data.Email != ""
+			for name, values := range r.URL.Query() {
+				if name == "email" || name == "provider" || name == selectProviderParameter {
+					continue
+				}
+				for _, value := range values {
+					data.HiddenParameters = append(data.HiddenParameters, HiddenParameter{Name: name, Value: value})
+				}
+			}
+		}
+
 		w.Header().Add("Cache-Control", "no-cache")
-		tm.servePage(w, "login_provider_selection", loginProviderData)
+		tm.servePage(w, "login_provider_selection", data)
 	})
 }
 
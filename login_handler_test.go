@@ -0,0 +1,51 @@
+package oidcproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelectProvider(t *testing.T) {
+	corp := &Provider{id: "corp", config: &ProviderConfig{
+		EmailDomains: []string{"corp.example.com"},
+		Hosts:        []string{"corp.example.org"},
+	}}
+	social := &Provider{id: "social", config: &ProviderConfig{}}
+	sm := newTestSessionManager(t, NewDefaultSessionManagerOptions(), corp, social)
+
+	redirectValidator := &RedirectValidator{AllowedHosts: []string{"app.example.org"}}
+
+	// cookie of a previous login with social
+	recorder := httptest.NewRecorder()
+	sm.SetLastProvider(recorder, httptest.NewRequest(http.MethodGet, "/", nil), social.ID())
+	lastProviderCookie := recorder.Result().Cookies()[0]
+
+	for _, test := range []struct {
+		url          string
+		lastProvider bool
+		provider     *Provider
+		reason       string
+	}{
+		{"https://app.example.org/auth/login", false, nil, ""},
+		{"https://app.example.org/auth/login?rd=%2Fapp%3Fprovider%3Dsocial", false, social, "hint"},
+		{"https://app.example.org/auth/login?rd=%2Fapp%3Fprovider%3Dunknown", false, nil, ""},
+		{"https://app.example.org/auth/login?rd=https%3A%2F%2Fapp.example.org%2Fapp%3Fprovider%3Dsocial", false, social, "hint"},
+		{"https://app.example.org/auth/login?rd=https%3A%2F%2Fevil.example.com%2Fapp%3Fprovider%3Dsocial", false, nil, ""},
+		{"https://app.example.org/auth/login?rd=%2F%2Fevil.example.com%2Fapp%3Fprovider%3Dsocial", false, nil, ""},
+		{"https://app.example.org/auth/login?email=jane%40Corp.Example.com", false, corp, "email"},
+		{"https://app.example.org/auth/login?email=jane%40other.example.com", true, nil, "email"},
+		{"https://corp.example.org:8443/auth/login", true, corp, "host"},
+		{"https://app.example.org/auth/login", true, social, "last_provider"},
+		{"https://app.example.org/auth/login?select_provider", true, nil, ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, test.url, nil)
+		if test.lastProvider {
+			r.AddCookie(lastProviderCookie)
+		}
+		provider, reason := selectProvider(sm, redirectValidator, r)
+		if provider != test.provider || reason != test.reason {
+			t.Errorf("%s: got %v (%s), want %v (%s)", test.url, provider, reason, test.provider, test.reason)
+		}
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"net/http"
 )
 
+// NewMainHandler returns the handler of the proxy. The background discovery
+// of the providers runs until the process exits. Use NewApp and App.Close to
+// stop it.
 func NewMainHandler(config *Config, next http.Handler) (http.Handler, error) {
 	a, err := NewApp(config)
 	if err != nil {
@@ -14,10 +17,11 @@ func NewMainHandler(config *Config, next http.Handler) (http.Handler, error) {
 }
 
 type App struct {
-	Config          *Config
-	TemplateManager *templateManager
-	SessionManager  *sessionManager
-	Providers       []*Provider
+	Config            *Config
+	TemplateManager   *templateManager
+	SessionManager    *sessionManager
+	RedirectValidator *RedirectValidator
+	Providers         []*Provider
 }
 
 func NewApp(c *Config) (*App, error) {
@@ -31,44 +35,74 @@ func NewApp(c *Config) (*App, error) {
 		return nil, err
 	}
 
-	// TODO: not nice
-	providers, err := NewProviderSet(context.TODO(), c.Providers, func(pc *ProviderConfig) {
+	// the context stops the background discovery of the providers on
+	// Close.
+	ctx, cancel := context.WithCancel(context.Background())
+	providers, err := NewProviderSet(ctx, c.Providers, func(pc *ProviderConfig) {
 		if pc.CallbackURL == "" {
 			pc.CallbackURL = c.CallbackURL
 		}
 
 		if pc.PostLogoutRedirectURI == "" {
-			pc.PostLogoutRedirectURI = c.PostLogoutRediretURI
+			pc.PostLogoutRedirectURI = c.postLogoutRedirectURI(pc.CallbackURL)
 		}
 	})
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
 	providerSet, err := newProviderSet(providers...)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
-
-	sm, err := NewSessionManager(c.HashKey, c.EncryptKey, providerSet, c.CookieConfig)
+	providerSet.cancel = cancel
+
+	sm, err := NewSessionManager(c.HashKey, c.EncryptKey, providerSet, c.CookieConfig, SessionManagerOptions{
+		SessionCookieName:      c.SessionCookieName,
+		LoginStateCookieName:   c.LoginStateCookieName,
+		LastProviderCookieName: c.LastProviderCookieName,
+		LoginStateTTL:          c.LoginStateTTL,
+		LastProviderTTL:        c.LastProviderTTL,
+		RevocationTTL:          c.RevocationTTL,
+		RevocationStore:        c.RevocationStore,
+		SessionBinding:         c.SessionBinding,
+		StepUp:                 c.StepUp,
+		SilentLogin:            c.SilentLogin,
+	})
 	if err != nil {
+		providerSet.Close()
 		return nil, err
 	}
 
+	redirectValidator := &RedirectValidator{
+		AllowedHosts:   c.RedirectAllowedHosts,
+		AllowedSchemes: c.RedirectAllowedSchemes,
+	}
+
 	return &App{
-		Config:          c,
-		TemplateManager: tm,
-		SessionManager:  sm,
-		Providers:       providers,
+		Config:            c,
+		TemplateManager:   tm,
+		SessionManager:    sm,
+		RedirectValidator: redirectValidator,
+		Providers:         providers,
 	}, nil
 }
 
+// Close stops the background tasks of the app (e.g. the rediscovery of the
+// providers).
+func (a *App) Close() {
+	a.SessionManager.providerSet.Close()
+}
+
 func (a *App) NewAuthHandler(next http.Handler) http.Handler {
 	mux := http.NewServeMux()
 
 	// login
 	mux.Handle(a.Config.LoginPath, LoginHandler(
 		a.SessionManager,
+		a.RedirectValidator,
 		ProviderSelectionHandler(
 			a.Config.AppName,
 			a.Providers,
@@ -77,10 +111,14 @@ func (a *App) NewAuthHandler(next http.Handler) http.Handler {
 	))
 
 	// callback
-	mux.Handle(a.Config.CallbackPath, CallbackHandler(
+	mux.Handle(a.Config.CallbackPath, FormPostHandler(
 		a.SessionManager,
-		defaultPostCallbackHandler(a.SessionManager, defaultErrorHandler, a.Config.ExternalSessionInfoPath),
-		defaultErrorHandler,
+		a.TemplateManager,
+		CallbackHandler(
+			a.SessionManager,
+			defaultPostCallbackHandler(a.SessionManager, a.RedirectValidator, defaultErrorHandler, a.Config.ExternalSessionInfoPath),
+			defaultErrorHandler,
+		),
 	))
 
 	// info
@@ -100,10 +138,29 @@ func (a *App) NewAuthHandler(next http.Handler) http.Handler {
 	}), defaultErrorHandler))
 
 	// logout
-	mux.Handle(a.Config.LogoutPath, LogoutHandler(a.SessionManager, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle(a.Config.LogoutPath, LogoutHandler(a.SessionManager, a.TemplateManager, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, a.Config.ExternalSessionInfoPath, http.StatusSeeOther)
 	})))
 
+	// post logout
+	mux.Handle(a.Config.PostLogoutPath, PostLogoutHandler(a.SessionManager, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, a.Config.ExternalSessionInfoPath, http.StatusSeeOther)
+	})))
+
+	// back-channel logout
+	mux.Handle(a.Config.BackChannelLogoutPath, BackChannelLogoutHandler(a.SessionManager))
+
+	// front-channel logout
+	if a.Config.FrontChannelLogoutPath != "" {
+		mux.Handle(a.Config.FrontChannelLogoutPath, FrontChannelLogoutHandler(a.SessionManager))
+	}
+
+	// client metadata
+	mux.Handle(a.Config.ClientMetadataPath, ClientMetadataHandler(a.Providers, a.Config.ExternalFrontChannelLogoutPath, a.Config.ExternalBackChannelLogoutPath))
+
+	// health
+	mux.Handle(a.Config.HealthPath, HealthHandler(a.Providers))
+
 	// root
 	mux.Handle("/", AuthenticateHandler(a.SessionManager, a.Config.ExternalLoginPath, next))
 
@@ -0,0 +1,93 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Confirmation is the cnf claim of a certificate bound access token. See
+// https://www.rfc-editor.org/rfc/rfc8705#section-3.1.
+type Confirmation struct {
+	X5TS256 string `json:"x5t#S256,omitempty"`
+}
+
+// usesMTLS returns true if the provider presents a client certificate to
+// the identity provider. In this case the mTLS endpoint aliases are used.
+func (p *Provider) usesMTLS(tokenAuthMethod ClientAuthMethod) bool {
+	return tokenAuthMethod.usesTLSClientCert() || p.config.TLSClientCertificateBoundAccessTokens
+}
+
+// setMTLSAliases replaces the endpoints with the mTLS endpoint aliases of the
+// discovery. See https://www.rfc-editor.org/rfc/rfc8705#section-5.
+func (e *Endpoints) setMTLSAliases(aliases *Endpoints) {
+	if aliases.TokenEndpoint != "" {
+		e.TokenEndpoint = aliases.TokenEndpoint
+	}
+	if aliases.IntrospectionEndpoint != "" {
+		e.IntrospectionEndpoint = aliases.IntrospectionEndpoint
+	}
+	if aliases.UserinfoEndpoint != "" {
+		e.UserinfoEndpoint = aliases.UserinfoEndpoint
+	}
+	if aliases.RevocationEndpoint != "" {
+		e.RevocationEndpoint = aliases.RevocationEndpoint
+	}
+	if aliases.PushedAuthorizationRequestEndpoint != "" {
+		e.PushedAuthorizationRequestEndpoint = aliases.PushedAuthorizationRequestEndpoint
+	}
+}
+
+// clientCertThumbprint returns the base64url encoded SHA-256 thumbprint of
+// the TLS client certificate.
+func (p *Provider) clientCertThumbprint() string {
+	cert := p.config.HTTPClientConfig.TLSClientCert
+	if cert == nil || len(cert.Certificate) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Certificate[0])
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyCertificateBinding checks that the access token is bound to the TLS
+// client certificate. The cnf claim is read from the access token if it is a
+// JWT and otherwise using the token introspection. The token is received
+// directly from the token endpoint, hence we do not verify its signature.
+func (p *Provider) verifyCertificateBinding(ctx context.Context, accessToken string) error {
+	thumbprint := p.clientCertThumbprint()
+	if thumbprint == "" {
+		return fmt.Errorf("tls client certificate missing")
+	}
+
+	claims := struct {
+		Confirmation *Confirmation `json:"cnf"`
+	}{}
+	if strings.Count(accessToken, ".") == 2 {
+		err := unverifiedClaims(accessToken, &claims)
+		if err != nil {
+			return fmt.Errorf("invalid access token: %w", err)
+		}
+	} else {
+		introspection, err := p.Introspect(ctx, accessToken, "access_token")
+		if err == ErrNotSupported {
+			return fmt.Errorf("opaque access token can not be verified without introspection endpoint")
+		}
+		if err != nil {
+			return err
+		}
+		if !introspection.Active {
+			return fmt.Errorf("access token is not active")
+		}
+		claims.Confirmation = introspection.Confirmation
+	}
+
+	if claims.Confirmation == nil || claims.Confirmation.X5TS256 == "" {
+		return fmt.Errorf("access token is not certificate bound")
+	}
+	if claims.Confirmation.X5TS256 != thumbprint {
+		return fmt.Errorf("access token is bound to a different certificate")
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMTLSEndpointAliases(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"revocation_endpoint":    server.URL + "/revoke",
+			"introspection_endpoint": server.URL + "/introspect",
+			"jwks_uri":               server.URL + "/keys",
+			"mtls_endpoint_aliases": map[string]any{
+				"token_endpoint":         "https://mtls.example.com/token",
+				"revocation_endpoint":    "https://mtls.example.com/revoke",
+				"introspection_endpoint": "https://mtls.example.com/introspect",
+			},
+		})
+	}))
+	defer server.Close()
+
+	cert := &tls.Certificate{Certificate: [][]byte{[]byte("cert1")}}
+	for _, test := range []struct {
+		name   string
+		config ProviderConfig
+		base   string
+	}{
+		{"client secret", ProviderConfig{ClientSecret: "secret"}, server.URL},
+		{"tls client auth", ProviderConfig{ClientAuthMethod: TLSClientAuth, HTTPClientConfig: HTTPClientConfig{TLSClientCert: cert}}, "https://mtls.example.com"},
+		{"certificate bound tokens", ProviderConfig{ClientSecret: "secret", TLSClientCertificateBoundAccessTokens: true, HTTPClientConfig: HTTPClientConfig{TLSClientCert: cert}}, "https://mtls.example.com"},
+	} {
+		config := test.config
+		config.IssuerURL = server.URL
+		config.ClientID = "client"
+		config.HTTPClient = server.Client()
+		p, err := NewProvider(context.Background(), config)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		state, err := p.currentState()
+		if err != nil {
+			t.Fatal(err)
+		}
+		endpoints := state.endpoints
+		if endpoints.TokenEndpoint != test.base+"/token" || endpoints.RevocationEndpoint != test.base+"/revoke" || endpoints.IntrospectionEndpoint != test.base+"/introspect" {
+			t.Errorf("%s: unexpected endpoints %+v", test.name, endpoints)
+		}
+		if endpoints.AuthorizationEndpoint != server.URL+"/authorize" {
+			t.Errorf("%s: authorization endpoint replaced: %s", test.name, endpoints.AuthorizationEndpoint)
+		}
+	}
+}
+
+func TestVerifyCertificateBinding(t *testing.T) {
+	cert := &tls.Certificate{Certificate: [][]byte{[]byte("cert1")}}
+	sum := sha256.Sum256(cert.Certificate[0])
+	thumbprint := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	p := &Provider{id: "p1", config: &ProviderConfig{HTTPClientConfig: HTTPClientConfig{TLSClientCert: cert}}}
+	p.state.Store(&providerState{})
+	if p.clientCertThumbprint() != thumbprint {
+		t.Fatalf("got thumbprint %s, want %s", p.clientCertThumbprint(), thumbprint)
+	}
+
+	for _, test := range []struct {
+		name        string
+		accessToken string
+		valid       bool
+	}{
+		{"match", newTestJWT(map[string]any{"sub": "user1", "cnf": map[string]any{"x5t#S256": thumbprint}}), true},
+		{"mismatch", newTestJWT(map[string]any{"sub": "user1", "cnf": map[string]any{"x5t#S256": "other"}}), false},
+		{"cnf missing", newTestJWT(map[string]any{"sub": "user1"}), false},
+		{"opaque without introspection", "opaque-token", false},
+	} {
+		err := p.verifyCertificateBinding(context.Background(), test.accessToken)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got err=%v, want valid=%t", test.name, err, test.valid)
+		}
+	}
+
+	// without client certificate no token is accepted
+	p = &Provider{id: "p1", config: &ProviderConfig{}}
+	err := p.verifyCertificateBinding(context.Background(), newTestJWT(map[string]any{"cnf": map[string]any{"x5t#S256": thumbprint}}))
+	if err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+}
@@ -0,0 +1,122 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// PARMode specifies if the authorization parameters are sent to the provider
+// using Pushed Authorization Requests (see
+// https://www.rfc-editor.org/rfc/rfc9126).
+type PARMode string
+
+const (
+	// PARAuto uses pushed authorization requests if the provider has a
+	// pushed_authorization_request_endpoint. If the pushed authorization
+	// request fails the parameters are sent in the URL unless the
+	// provider requires pushed authorization requests.
+	PARAuto PARMode = "auto"
+	// PARAlways requires pushed authorization requests. The discovery
+	// fails if the provider has no pushed_authorization_request_endpoint.
+	PARAlways PARMode = "always"
+	// PARNever sends the authorization parameters in the URL.
+	PARNever PARMode = "never"
+)
+
+func (m PARMode) Validate() error {
+	switch m {
+	case "", PARAuto, PARAlways, PARNever:
+		return nil
+	default:
+		return fmt.Errorf("invalid pushed authorization request mode '%s'. allowed modes are %s, %s and %s", m, PARAuto, PARAlways, PARNever)
+	}
+}
+
+// usePAR decides based on the mode and the discovered endpoints if pushed
+// authorization requests are used.
+func (p *Provider) usePAR(endpoints *Endpoints, metadata *providerMetadata) (bool, error) {
+	switch p.config.PushedAuthorizationRequests {
+	case PARNever:
+		if metadata.RequirePushedAuthorizationRequests {
+			slog.Warn("provider requires pushed authorization requests but they are disabled", "provider", p.String())
+		}
+		return false, nil
+	case PARAlways:
+		if endpoints.PushedAuthorizationRequestEndpoint == "" {
+			return false, fmt.Errorf("pushed authorization request endpoint not set")
+		}
+		return true, nil
+	default:
+		return endpoints.PushedAuthorizationRequestEndpoint != "", nil
+	}
+}
+
+// parFallback decides if the parameters are sent in the URL if a pushed
+// authorization request fails.
+func (p *Provider) parFallback(metadata *providerMetadata) bool {
+	mode := p.config.PushedAuthorizationRequests
+	return (mode == "" || mode == PARAuto) && !metadata.RequirePushedAuthorizationRequests
+}
+
+// parResponse is the response of the pushed authorization request endpoint.
+// See https://www.rfc-editor.org/rfc/rfc9126#section-2.2.
+type parResponse struct {
+	RequestURI string `json:"request_uri"`
+	ExpiresIn  int    `json:"expires_in"`
+}
+
+// pushAuthorizationRequest sends the parameters of the authorization request
+// to the pushed authorization request endpoint and returns the URL of the
+// authorization endpoint which only contains the client_id and the returned
+// request_uri.
+func (p *Provider) pushAuthorizationRequest(ctx context.Context, state *providerState, parameters url.Values) (string, error) {
+	body := url.Values{}
+	for name, values := range parameters {
+		body[name] = values
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", state.endpoints.PushedAuthorizationRequestEndpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("pushed authorization request failed: %w", err)
+	}
+	err = p.authenticateRequest(state, state.tokenAuthMethod, req, body)
+	if err != nil {
+		return "", fmt.Errorf("pushed authorization request failed: %w", err)
+	}
+	setFormBody(req, body)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("pushed authorization request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return "", fmt.Errorf("pushed authorization request failed: returned status code %d with body '%s'", resp.StatusCode, body)
+	}
+
+	parResp := &parResponse{}
+	err = json.NewDecoder(resp.Body).Decode(parResp)
+	if err != nil {
+		return "", fmt.Errorf("pushed authorization request failed: invalid response: %w", err)
+	}
+	if parResp.RequestURI == "" {
+		return "", fmt.Errorf("pushed authorization request failed: request_uri missing in response")
+	}
+
+	authURL, err := url.Parse(state.endpoints.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("client_id", p.config.ClientID)
+	query.Set("request_uri", parResp.RequestURI)
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
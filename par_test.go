@@ -0,0 +1,154 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestPushedAuthorizationRequest(t *testing.T) {
+	var pushed url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "client" || password != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_ = r.ParseForm()
+		pushed = r.PostForm
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(parResponse{
+			RequestURI: "urn:ietf:params:oauth:request_uri:abc",
+			ExpiresIn:  60,
+		})
+	}))
+	defer server.Close()
+
+	p := &Provider{
+		config: &ProviderConfig{
+			ClientID:     "client",
+			ClientSecret: "secret",
+		},
+		httpClient: server.Client(),
+	}
+	endpoints := Endpoints{
+		AuthorizationEndpoint:              "https://idp.example.com/authorize",
+		TokenEndpoint:                      server.URL + "/token",
+		PushedAuthorizationRequestEndpoint: server.URL + "/par",
+	}
+	p.state.Store(&providerState{
+		endpoints: endpoints,
+		oauth2Config: &oauth2.Config{
+			ClientID:    "client",
+			RedirectURL: "https://app.example.com/callback",
+			Scopes:      []string{"openid"},
+			Endpoint:    oauth2.Endpoint{AuthURL: endpoints.AuthorizationEndpoint},
+		},
+		oauth2HTTPClient: server.Client(),
+		tokenAuthMethod:  ClientSecretBasic,
+		usePAR:           true,
+	})
+
+	authURL, err := p.AuthorizationEndpoint(context.Background(), "state1", oauth2.SetAuthURLParam("login_hint", "jane"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pushed.Get("state") != "state1" || pushed.Get("login_hint") != "jane" || pushed.Get("redirect_uri") != "https://app.example.com/callback" {
+		t.Errorf("unexpected pushed parameters: %v", pushed)
+	}
+
+	expected := "https://idp.example.com/authorize?client_id=client&request_uri=urn%3Aietf%3Aparams%3Aoauth%3Arequest_uri%3Aabc"
+	if authURL != expected {
+		t.Errorf("got %s, want %s", authURL, expected)
+	}
+}
+
+func TestUsePAR(t *testing.T) {
+	withEndpoint := &Endpoints{PushedAuthorizationRequestEndpoint: "https://idp.example.com/par"}
+	withoutEndpoint := &Endpoints{}
+
+	for _, test := range []struct {
+		mode      PARMode
+		endpoints *Endpoints
+		use       bool
+		err       bool
+	}{
+		{"", withEndpoint, true, false},
+		{PARAuto, withoutEndpoint, false, false},
+		{PARAlways, withEndpoint, true, false},
+		{PARAlways, withoutEndpoint, false, true},
+		{PARNever, withEndpoint, false, false},
+	} {
+		p := &Provider{config: &ProviderConfig{PushedAuthorizationRequests: test.mode}}
+		use, err := p.usePAR(test.endpoints, &providerMetadata{})
+		if use != test.use || (err != nil) != test.err {
+			t.Errorf("mode=%s endpoint=%s: got %t, %v", test.mode, test.endpoints.PushedAuthorizationRequestEndpoint, use, err)
+		}
+	}
+}
+
+func TestPushedAuthorizationRequestFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	for _, test := range []struct {
+		mode     PARMode
+		required bool
+		fallback bool
+	}{
+		{"", false, true},
+		{PARAuto, false, true},
+		{PARAuto, true, false},
+		{PARAlways, false, false},
+	} {
+		p := &Provider{
+			config: &ProviderConfig{
+				ClientID:                    "client",
+				ClientSecret:                "secret",
+				PushedAuthorizationRequests: test.mode,
+			},
+			httpClient: server.Client(),
+		}
+		p.state.Store(&providerState{
+			endpoints: Endpoints{
+				AuthorizationEndpoint:              "https://idp.example.com/authorize",
+				PushedAuthorizationRequestEndpoint: server.URL + "/par",
+			},
+			oauth2Config: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example.com/authorize"},
+			},
+			tokenAuthMethod: ClientSecretBasic,
+			usePAR:          true,
+			parFallback:     p.parFallback(&providerMetadata{RequirePushedAuthorizationRequests: test.required}),
+		})
+
+		authURL, err := p.AuthorizationEndpoint(context.Background(), "state1")
+		if !test.fallback {
+			if err == nil {
+				t.Errorf("mode=%s required=%t: expected error, got %s", test.mode, test.required, authURL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("mode=%s required=%t: unexpected error: %v", test.mode, test.required, err)
+			continue
+		}
+		u, err := url.Parse(authURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Host != "idp.example.com" || u.Query().Get("state") != "state1" || u.Query().Has("request_uri") {
+			t.Errorf("mode=%s required=%t: unexpected fallback url %s", test.mode, test.required, authURL)
+		}
+	}
+}
@@ -2,14 +2,22 @@ package oidcproxy
 
 import (
 	"context"
+	"crypto"
 	"crypto/sha1"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"golang.org/x/oauth2"
@@ -23,6 +31,7 @@ var ErrNotSupported = errors.New("not supported")
 type ProviderConfig struct {
 	ID                     string           `json:"id,omitempty"`
 	Name                   string           `json:"name"`
+	Type                   ProviderType     `json:"type,omitempty"`
 	IssuerURL              string           `json:"issuer_url"`
 	ClientID               string           `json:"client_id"`
 	ClientSecret           string           `json:"client_secret"`
@@ -32,6 +41,122 @@ type ProviderConfig struct {
 	CallbackURL            string           `json:"callback_url"`
 	PostLogoutRedirectURI  string           `json:"post_logout_redirect_uri"`
 	SetupSessionFunc       SessionSetupFunc `json:"-"`
+
+	// PassthroughParameters are the authorization parameters which can be
+	// set per login request as query parameters of the login endpoint
+	// (e.g. login_hint, prompt, max_age; see DefaultPassthroughParameters).
+	// They are forwarded to the provider and take precedence over
+	// AuthorizationParameter. If max_age is set, the auth_time of the
+	// id_token is verified on the callback.
+	PassthroughParameters []string `json:"passthrough_parameters,omitempty"`
+
+	// APIURL is the base URL of the API of the provider types github,
+	// gitlab and bitbucket. Set this for self-hosted instances.
+	APIURL string `json:"api_url,omitempty"`
+
+	// Tenant, Domain, BaseURL, Realm, HostedDomain and Audience configure
+	// the presets of the provider types:
+	//   - azure: Tenant
+	//   - google: HostedDomain restricts the login to a Google Workspace domain
+	//   - keycloak: BaseURL and Realm
+	//   - okta: Domain
+	//   - auth0: Domain and Audience (the API for which the access token is issued)
+	Tenant       string `json:"tenant,omitempty"`
+	Domain       string `json:"domain,omitempty"`
+	BaseURL      string `json:"base_url,omitempty"`
+	Realm        string `json:"realm,omitempty"`
+	HostedDomain string `json:"hosted_domain,omitempty"`
+	Audience     string `json:"audience,omitempty"`
+
+	// UsernameClaim and GroupsClaim specify the id_token claims which are
+	// used as the name and the groups of the user. Nested claims are
+	// separated by dots (e.g. realm_access.roles).
+	UsernameClaim string `json:"username_claim,omitempty"`
+	GroupsClaim   string `json:"groups_claim,omitempty"`
+
+	// SkipIssuerCheck allows providers whose discovered issuer does not
+	// match the issuer URL (e.g. the multi-tenant endpoints of Azure). The
+	// issuer of the tokens is then verified against IssuerTemplate and
+	// AllowedTenants. IssuerTemplate defaults to the discovered issuer.
+	// The placeholder {tenantid} in the template matches the tenant. Use
+	// '*' in AllowedTenants to allow all tenants.
+	SkipIssuerCheck bool     `json:"skip_issuer_check,omitempty"`
+	IssuerTemplate  string   `json:"issuer_template,omitempty"`
+	AllowedTenants  []string `json:"allowed_tenants,omitempty"`
+
+	// AllowedGroups restricts the login to users which are member of at
+	// least one of the groups. For github the groups are the
+	// organizations and teams (<org>/<team>) of the user.
+	AllowedGroups []string `json:"allowed_groups,omitempty"`
+
+	// EmailDomains and Hosts are used to select the provider if multiple
+	// providers are configured. A user who enters an email address of
+	// one of the EmailDomains is routed to this provider. Requests to one
+	// of the Hosts use this provider without showing the provider
+	// selection.
+	EmailDomains []string `json:"email_domains,omitempty"`
+	Hosts        []string `json:"hosts,omitempty"`
+
+	// TokenRetention specifies which tokens are kept in the session.
+	TokenRetention TokenRetentionPolicy `json:"token_retention"`
+
+	// ClientAuthMethod specifies how the client authenticates at the
+	// provider. If not set it is selected based on the configured
+	// credentials and the methods supported by the provider.
+	ClientAuthMethod ClientAuthMethod `json:"client_auth_method,omitempty"`
+
+	// ClientKey is used to sign the client assertions of the
+	// private_key_jwt client authentication. If not set the key is read
+	// from ClientKeyFile.
+	ClientKey     crypto.Signer `json:"-"`
+	ClientKeyFile string        `json:"client_key_file,omitempty"`
+
+	// ClientKeyID is set as kid in the header of the client assertions.
+	ClientKeyID string `json:"client_key_id,omitempty"`
+
+	// ClientAssertionAlgorithm is the algorithm to sign the client
+	// assertions. If not set it is derived from the client key and HS256
+	// is used for client_secret_jwt.
+	ClientAssertionAlgorithm string `json:"client_assertion_algorithm,omitempty"`
+
+	// TLSClientCertificateBoundAccessTokens requests access tokens which
+	// are bound to the TLS client certificate and verifies that the
+	// issued access tokens are bound to it.
+	TLSClientCertificateBoundAccessTokens bool `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+
+	// PushedAuthorizationRequests specifies if the authorization
+	// parameters are pushed to the provider instead of sending them in
+	// the URL (see PARMode). Defaults to auto.
+	PushedAuthorizationRequests PARMode `json:"pushed_authorization_requests,omitempty"`
+
+	// RequestObject sends the authorization parameters as signed request
+	// object (see https://www.rfc-editor.org/rfc/rfc9101). The request
+	// object is signed with the ClientKey or if not available with the
+	// ClientSecret.
+	RequestObject bool `json:"request_object,omitempty"`
+
+	// ResponseMode is set as response_mode in the authorization
+	// requests (query, form_post, jwt, query.jwt or form_post.jwt). With
+	// form_post the provider posts the response to the callback (e.g.
+	// required by Apple or by Azure if an id_token is requested). With
+	// jwt, query.jwt and form_post.jwt the provider returns a signed
+	// authorization response (JARM) which is verified on the callback.
+	ResponseMode string `json:"response_mode,omitempty"`
+
+	// DeferDiscovery performs the discovery in the background. Until the
+	// discovery succeeded the provider is not ready and logins fail. The
+	// discovery is retried with an exponential backoff.
+	DeferDiscovery bool `json:"defer_discovery,omitempty"`
+
+	// RediscoveryInterval specifies how often the discovery is repeated
+	// to pick up changed endpoints and keys. If not set the discovery is
+	// only performed once.
+	RediscoveryInterval Duration `json:"rediscovery_interval,omitempty"`
+
+	// HTTPClient is used for all requests to the provider. If not set a
+	// client based on HTTPClientConfig is used.
+	HTTPClient       *http.Client     `json:"-"`
+	HTTPClientConfig HTTPClientConfig `json:"http_client"`
 	Endpoints
 }
 
@@ -42,15 +167,48 @@ func (pc *ProviderConfig) Clone() ProviderConfig {
 	clone.Scopes = make([]string, len(pc.Scopes))
 	copy(clone.Scopes, pc.Scopes)
 
+	// AllowedGroups
+	clone.AllowedGroups = slices.Clone(pc.AllowedGroups)
+
+	// AllowedTenants
+	clone.AllowedTenants = slices.Clone(pc.AllowedTenants)
+
+	// PassthroughParameters
+	clone.PassthroughParameters = slices.Clone(pc.PassthroughParameters)
+
+	// EmailDomains and Hosts
+	clone.EmailDomains = slices.Clone(pc.EmailDomains)
+	clone.Hosts = slices.Clone(pc.Hosts)
+
 	// AuthorizationParameter
 	clone.AuthorizationParameter = url.Values(http.Header(pc.AuthorizationParameter).Clone())
 
 	// TokenParameters
 	clone.TokenParameters = url.Values(http.Header(pc.TokenParameters).Clone())
 
+	// HTTPClientConfig.Headers
+	if pc.HTTPClientConfig.Headers != nil {
+		clone.HTTPClientConfig.Headers = make(map[string]string, len(pc.HTTPClientConfig.Headers))
+		for name, value := range pc.HTTPClientConfig.Headers {
+			clone.HTTPClientConfig.Headers[name] = value
+		}
+	}
+
 	return clone
 }
 
+// providerMetadata contains the provider metadata from the discovery which is
+// not covered by Endpoints.
+type providerMetadata struct {
+	Issuer                                    string    `json:"issuer"`
+	TokenEndpointAuthMethodsSupported         []string  `json:"token_endpoint_auth_methods_supported"`
+	RevocationEndpointAuthMethodsSupported    []string  `json:"revocation_endpoint_auth_methods_supported"`
+	IntrospectionEndpointAuthMethodsSupported []string  `json:"introspection_endpoint_auth_methods_supported"`
+	MTLSEndpointAliases                       Endpoints `json:"mtls_endpoint_aliases"`
+	RequirePushedAuthorizationRequests        bool      `json:"require_pushed_authorization_requests"`
+	AuthorizationSigningAlgValuesSupported    []string  `json:"authorization_signing_alg_values_supported"`
+}
+
 type Endpoints struct {
 	AuthorizationEndpoint string `json:"authorization_endpoint"`
 	TokenEndpoint         string `json:"token_endpoint"`
@@ -58,6 +216,8 @@ type Endpoints struct {
 	UserinfoEndpoint      string `json:"userinfo_endpoint"`
 	EndSessionEndpoint    string `json:"end_session_endpoint"`
 	RevocationEndpoint    string `json:"revocation_endpoint"`
+
+	PushedAuthorizationRequestEndpoint string `json:"pushed_authorization_request_endpoint"`
 }
 
 // Merge sets e to e2 if e is not set
@@ -80,6 +240,9 @@ func (e *Endpoints) Merge(e2 *Endpoints) {
 	if e.RevocationEndpoint == "" {
 		e.RevocationEndpoint = e2.RevocationEndpoint
 	}
+	if e.PushedAuthorizationRequestEndpoint == "" {
+		e.PushedAuthorizationRequestEndpoint = e2.PushedAuthorizationRequestEndpoint
+	}
 }
 
 func NewProvider(ctx context.Context, config ProviderConfig) (*Provider, error) {
@@ -91,63 +254,110 @@ func NewProvider(ctx context.Context, config ProviderConfig) (*Provider, error)
 		return nil, fmt.Errorf("client id missing in configuration")
 	}
 
-	sessionSetupFunc := defaultSessionSetupFunc
-	if config.SetupSessionFunc != nil {
-		sessionSetupFunc = ChainSessionSetupFunc(defaultSessionSetupFunc, config.SetupSessionFunc)
+	err = config.applyType()
+	if err != nil {
+		return nil, err
 	}
 
-	providerID := config.ID
-	if len(providerID) == 0 {
-		providerID = generateProviderIdentifier(&config)
+	err = config.PushedAuthorizationRequests.Validate()
+	if err != nil {
+		return nil, err
 	}
 
-	provider := &Provider{
-		id:     providerID,
-		config: &config,
-		oauth2Config: &oauth2.Config{
-			ClientID:     config.ClientID,
-			ClientSecret: config.ClientSecret,
-			Scopes:       config.Scopes,
-			RedirectURL:  config.CallbackURL,
-		},
-		oauth2AuthCodeOpts: urlValuesIntoOpts(config.AuthorizationParameter),
-		oauth2TokenOpts:    urlValuesIntoOpts(config.TokenParameters),
+	err = validateResponseMode(config.ResponseMode)
+	if err != nil {
+		return nil, err
+	}
 
-		oidcConfig: &oidc.Config{
-			ClientID: config.ClientID,
-		},
-		sessionSetupFunc: sessionSetupFunc,
+	err = validatePassthroughParameters(config.PassthroughParameters)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.TokenRetention.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid token retention policy: %w", err)
 	}
 
-	// TODO: add option do defer
-	if config.IssuerURL != "" {
-		provider.oidcProvider, err = oidc.NewProvider(ctx, config.IssuerURL)
+	if config.ClientKey == nil && config.ClientKeyFile != "" {
+		config.ClientKey, err = LoadClientKey(config.ClientKeyFile)
 		if err != nil {
 			return nil, err
 		}
-		endpoints := &Endpoints{}
-		err := provider.oidcProvider.Claims(endpoints)
+	}
+
+	err = config.HTTPClientConfig.loadClientCert()
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateClientAuthMethod(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.TLSClientCertificateBoundAccessTokens && config.HTTPClientConfig.TLSClientCert == nil {
+		return nil, fmt.Errorf("certificate bound access tokens require a tls client certificate")
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient, err = config.HTTPClientConfig.NewClient()
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	config.Scopes = config.TokenRetention.modifyScopes(config.Scopes)
 
-		// apply explicitly set settings which take precedence over the
-		// discoverd endpoints
-		provider.config.Endpoints.Merge(endpoints)
+	sessionSetupFuncs := []SessionSetupFunc{defaultSessionSetupFunc}
+	if typeSessionSetupFunc := config.typeSessionSetupFunc(); typeSessionSetupFunc != nil {
+		sessionSetupFuncs = append(sessionSetupFuncs, typeSessionSetupFunc)
+	}
+	if config.SetupSessionFunc != nil {
+		sessionSetupFuncs = append(sessionSetupFuncs, config.SetupSessionFunc)
+	}
+	// the claim mapping is applied after the custom functions so that
+	// the configured claims take precedence (e.g. over SaveGroups)
+	if config.UsernameClaim != "" || config.GroupsClaim != "" {
+		sessionSetupFuncs = append(sessionSetupFuncs, MapClaims(config.UsernameClaim, config.GroupsClaim))
 	}
+	if len(config.AllowedGroups) > 0 {
+		sessionSetupFuncs = append(sessionSetupFuncs, RequireGroup(config.AllowedGroups...))
+	}
+	sessionSetupFunc := ChainSessionSetupFunc(sessionSetupFuncs...)
 
-	if provider.config.AuthorizationEndpoint == "" {
-		return nil, fmt.Errorf("authorization endpoint not set")
+	providerID := config.ID
+	if len(providerID) == 0 {
+		providerID = generateProviderIdentifier(&config)
 	}
-	if provider.config.TokenEndpoint == "" {
-		return nil, fmt.Errorf("token endpoint not set")
+
+	authCodeOpts := urlValuesIntoOpts(config.AuthorizationParameter)
+	if config.ResponseMode != "" {
+		authCodeOpts = append(authCodeOpts, oauth2.SetAuthURLParam("response_mode", config.ResponseMode))
 	}
 
-	provider.oauth2Config.Endpoint = oauth2.Endpoint{
-		AuthURL:  provider.config.AuthorizationEndpoint,
-		TokenURL: provider.config.TokenEndpoint,
+	provider := &Provider{
+		id:                 providerID,
+		config:             &config,
+		oauth2AuthCodeOpts: authCodeOpts,
+		oauth2TokenOpts:    urlValuesIntoOpts(config.TokenParameters),
+
+		oidcConfig: &oidc.Config{
+			ClientID: config.ClientID,
+		},
+		sessionSetupFunc: sessionSetupFunc,
+		httpClient:       httpClient,
 	}
 
+	if !config.DeferDiscovery {
+		state, err := provider.discover(ctx)
+		if err != nil {
+			return nil, err
+		}
+		provider.state.Store(state)
+	}
+	provider.startDiscovery(ctx)
 	return provider, nil
 }
 
@@ -164,16 +374,34 @@ type Provider struct {
 	id     string
 	config *ProviderConfig
 
-	oidcProvider *oidc.Provider
-	oidcConfig   *oidc.Config
+	oidcConfig *oidc.Config
 
-	oauth2Config       *oauth2.Config
 	oauth2AuthCodeOpts []oauth2.AuthCodeOption
 	oauth2TokenOpts    []oauth2.AuthCodeOption
 
+	// httpClient is used for all requests to the provider.
+	httpClient *http.Client
+
+	// state is set as soon as the discovery succeeded.
+	state atomic.Pointer[providerState]
+
+	// discoveryErr is the error of the last failed discovery.
+	discoveryMu  sync.Mutex
+	discoveryErr error
+
+	// background waits for the background discovery to return.
+	background sync.WaitGroup
+
 	sessionSetupFunc SessionSetupFunc
 }
 
+// clientContext returns a context which makes the oidc and the oauth2
+// packages use the HTTP client of the provider.
+func (p *Provider) clientContext(ctx context.Context, state *providerState) context.Context {
+	ctx = oidc.ClientContext(ctx, p.httpClient)
+	return context.WithValue(ctx, oauth2.HTTPClient, state.oauth2HTTPClient)
+}
+
 // ID returns an identifier of the provider. If not set in ProviderConfig it gets calculated based on:
 //   - IssuerURL
 //   - ClientID
@@ -190,32 +418,81 @@ func (p *Provider) String() string {
 	return fmt.Sprintf("%s (%s)", p.config.Name, p.ID())
 }
 
+// Config returns the configuration of the provider. If the discovery
+// succeeded the endpoints contain the discovered endpoints.
 func (p *Provider) Config() ProviderConfig {
-	return p.config.Clone()
+	config := p.config.Clone()
+	if state := p.state.Load(); state != nil {
+		config.Endpoints = state.endpoints
+	}
+	return config
 }
 
 // AuthorizationEndpoint returns the authorization endpoint where redirect
-// clients to initiate a login.
-func (p *Provider) AuthorizationEndpoint(ctx context.Context, state string) (string, error) {
-	// NOTE: might return error in the future if the provider is setup asynchronously.
-	return p.oauth2Config.AuthCodeURL(state, p.oauth2AuthCodeOpts...), nil
+// clients to initiate a login. The opts are added to the configured
+// authorization parameters. The parameters are signed as request object if
+// configured. If pushed authorization requests are used the parameters are
+// pushed to the provider first. It returns ErrProviderNotReady
+// if the discovery has not succeeded yet.
+func (p *Provider) AuthorizationEndpoint(ctx context.Context, state string, opts ...oauth2.AuthCodeOption) (string, error) {
+	providerState, err := p.currentState()
+	if err != nil {
+		return "", err
+	}
+	opts = append(slices.Clone(p.oauth2AuthCodeOpts), opts...)
+	authCodeURL := providerState.oauth2Config.AuthCodeURL(state, opts...)
+	if !providerState.usePAR && !p.config.RequestObject {
+		return authCodeURL, nil
+	}
+
+	parsedURL, err := url.Parse(authCodeURL)
+	if err != nil {
+		return "", err
+	}
+	parameters := parsedURL.Query()
+
+	if p.config.RequestObject {
+		parameters, err = p.requestObject(providerState, parameters)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// with pushed authorization requests the parameters are sent to the
+	// provider directly and only referenced in the URL.
+	if providerState.usePAR {
+		pushedURL, err := p.pushAuthorizationRequest(ctx, providerState, parameters)
+		if err == nil || !providerState.parFallback {
+			return pushedURL, err
+		}
+		slog.Warn("pushed authorization request failed: send parameters in url", "provider", p.String(), "err", err)
+	}
+
+	parsedURL.RawQuery = parameters.Encode()
+	return parsedURL.String(), nil
 }
 
 // Exchange performs the Access Token Request using code. See
 // https://datatracker.ietf.org/doc/html/rfc6749#section-4.1.3. Based on the
 // returned Access Token Response it returns a session (see SessionSetupFunc).
 func (p *Provider) Exchange(ctx context.Context, code string, opts ...oauth2.AuthCodeOption) (*Session, error) {
-	oauth2Token, err := p.oauth2Config.Exchange(ctx, code, p.oauth2TokenOpts...)
+	state, err := p.currentState()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = p.clientContext(ctx, state)
+	oauth2Token, err := state.oauth2Config.Exchange(ctx, code, p.oauth2TokenOpts...)
 	if err != nil {
 		return nil, err
 	}
 
-	tr, err := p.intoTokenResponse(ctx, oauth2Token)
+	tr, err := p.intoTokenResponse(ctx, state, oauth2Token, false)
 	if err != nil {
 		return nil, err
 	}
 
-	return p.newSession(ctx, tr)
+	return p.newSession(ctx, state, tr)
 }
 
 // Refresh uses the refresh token of an existing session to obtain a new
@@ -230,21 +507,62 @@ func (p *Provider) Refresh(ctx context.Context, session *Session) (*Session, err
 		return nil, fmt.Errorf("missing refresh token")
 	}
 
+	state, err := p.currentState()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = p.clientContext(ctx, state)
+
 	// we deliberately only set the refresh_token to force the renewal
 	refreshTokenSource := &oauth2.Token{
 		RefreshToken: session.RefreshToken(),
 	}
-	oauth2Tokens, err := p.oauth2Config.TokenSource(ctx, refreshTokenSource).Token()
+	oauth2Tokens, err := state.oauth2Config.TokenSource(ctx, refreshTokenSource).Token()
 	if err != nil {
 		return nil, fmt.Errorf("token refresh failed: %w", err)
 	}
 
-	tr, err := p.intoTokenResponse(ctx, oauth2Tokens)
+	tr, err := p.intoTokenResponse(ctx, state, oauth2Tokens, true)
 	if err != nil {
 		return nil, err
 	}
 
-	return p.newSession(ctx, tr)
+	newSession, err := p.newSession(ctx, state, tr)
+	if err != nil {
+		return nil, err
+	}
+	newSession.carryOver(session)
+	return newSession, nil
+}
+
+// RevokeSession revokes the refresh token and the access token of the
+// session. Not all providers invalidate the access tokens of a revoked refresh
+// token, hence we revoke both. RevokeSession does return ErrNotSupported if no
+// revocation endpoint is configured.
+func (p *Provider) RevokeSession(ctx context.Context, session *Session) error {
+	state, err := p.currentState()
+	if err != nil {
+		return err
+	}
+	if state.endpoints.RevocationEndpoint == "" {
+		return ErrNotSupported
+	}
+
+	var errs []error
+	if session.HasRefreshToken() {
+		err := p.RevokeWithHint(ctx, session.RefreshToken(), "refresh_token")
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if session.HasAccessToken() {
+		err := p.RevokeWithHint(ctx, session.AccessToken(), "access_token")
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // Revoke revokes a token using the revocation endpoint. See
@@ -255,22 +573,38 @@ func (p *Provider) Refresh(ctx context.Context, session *Session) (*Session, err
 // invalidate all access tokens based on the same authorization grant`.
 // Revoke does return ErrNotSupported if no revocation endpoint is configured.
 func (p *Provider) Revoke(ctx context.Context, token string) error {
-	if p.config.RevocationEndpoint == "" {
+	return p.RevokeWithHint(ctx, token, "")
+}
+
+// RevokeWithHint revokes a token like Revoke and sends tokenTypeHint
+// (access_token or refresh_token) as token_type_hint. If tokenTypeHint is
+// empty no hint is sent.
+func (p *Provider) RevokeWithHint(ctx context.Context, token string, tokenTypeHint string) error {
+	state, err := p.currentState()
+	if err != nil {
+		return err
+	}
+	if state.endpoints.RevocationEndpoint == "" {
 		return ErrNotSupported
 	}
 
 	body := url.Values{}
 	body.Add("token", token)
-	body.Add("client_id", p.config.ClientID)
-	body.Add("client_secret", p.config.ClientSecret)
+	if tokenTypeHint != "" {
+		body.Add("token_type_hint", tokenTypeHint)
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", p.config.RevocationEndpoint, strings.NewReader(body.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", state.endpoints.RevocationEndpoint, nil)
+	if err != nil {
+		return fmt.Errorf("revocation failed: %w", err)
+	}
+	err = p.authenticateRequest(state, state.revocationAuthMethod, req, body)
 	if err != nil {
 		return fmt.Errorf("revocation failed: %w", err)
 	}
-	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	setFormBody(req, body)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := p.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("revocation failed: %w", err)
 	}
@@ -283,26 +617,116 @@ func (p *Provider) Revoke(ctx context.Context, token string) error {
 	return nil
 }
 
+// IntrospectionResponse is the response of the token introspection. See
+// https://www.rfc-editor.org/rfc/rfc7662#section-2.2.
+type IntrospectionResponse struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Expiry    int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  any    `json:"aud,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	ID        string `json:"jti,omitempty"`
+
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+}
+
+// Introspect returns the state of a token using the introspection endpoint.
+// See https://www.rfc-editor.org/rfc/rfc7662. The tokenTypeHint
+// (access_token or refresh_token) is optional. Introspect does return
+// ErrNotSupported if no introspection endpoint is configured.
+func (p *Provider) Introspect(ctx context.Context, token string, tokenTypeHint string) (*IntrospectionResponse, error) {
+	state, err := p.currentState()
+	if err != nil {
+		return nil, err
+	}
+	if state.endpoints.IntrospectionEndpoint == "" {
+		return nil, ErrNotSupported
+	}
+
+	body := url.Values{}
+	body.Add("token", token)
+	if tokenTypeHint != "" {
+		body.Add("token_type_hint", tokenTypeHint)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", state.endpoints.IntrospectionEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("introspection failed: %w", err)
+	}
+	err = p.authenticateRequest(state, state.introspectionAuthMethod, req, body)
+	if err != nil {
+		return nil, fmt.Errorf("introspection failed: %w", err)
+	}
+	setFormBody(req, body)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return nil, fmt.Errorf("introspection failed: returned status code %d with body '%s'", resp.StatusCode, body)
+	}
+
+	introspection := &IntrospectionResponse{}
+	err = json.NewDecoder(resp.Body).Decode(introspection)
+	if err != nil {
+		return nil, fmt.Errorf("introspection failed: invalid response: %w", err)
+	}
+	return introspection, nil
+}
+
 // EndSessionEndpoint returns the logout URL for the RP initiated logout if the end_session_endpoint is
-// configured or an empty string otherwise.
+// configured or an empty string otherwise. The state is passed back to the
+// post_logout_redirect_uri.
 // https://openid.net/specs/openid-connect-rpinitiated-1_0.html
-func (p *Provider) EndSessionEndpoint(ctx context.Context, session *Session) (string, error) {
-	if p.config.EndSessionEndpoint == "" {
+func (p *Provider) EndSessionEndpoint(ctx context.Context, session *Session, state string) (string, error) {
+	providerState, err := p.currentState()
+	if err != nil {
+		return "", err
+	}
+	if providerState.endpoints.EndSessionEndpoint == "" {
 		return "", ErrNotSupported
 	}
 
 	q := url.Values{}
 
-	tokens := session.Tokens
-	if tokens != nil && tokens.IDToken != "" {
-		q.Add("id_token_hint", tokens.IDToken)
+	if idTokenHint := session.idTokenHint(); idTokenHint != "" {
+		q.Add("id_token_hint", idTokenHint)
+	} else {
+		// without id_token_hint the OP needs the client_id to validate
+		// the post_logout_redirect_uri
+		q.Add("client_id", p.config.ClientID)
 	}
 
 	if p.config.PostLogoutRedirectURI != "" {
 		q.Add("post_logout_redirect_uri", p.config.PostLogoutRedirectURI)
+		if state != "" {
+			q.Add("state", state)
+		}
 	}
 
-	return p.config.EndSessionEndpoint + "?" + q.Encode(), nil
+	return providerState.endpoints.EndSessionEndpoint + "?" + q.Encode(), nil
+}
+
+// setFormBody sets body as form encoded body of req.
+func setFormBody(req *http.Request, body url.Values) {
+	encodedBody := body.Encode()
+	req.Body = io.NopCloser(strings.NewReader(encodedBody))
+	req.ContentLength = int64(len(encodedBody))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(encodedBody)), nil
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 }
 
 func urlValuesIntoOpts(urlValues url.Values) []oauth2.AuthCodeOption {
@@ -315,21 +739,46 @@ func urlValuesIntoOpts(urlValues url.Values) []oauth2.AuthCodeOption {
 	return opts
 }
 
-func (p *Provider) newSession(ctx context.Context, tr *TokenResponse) (*Session, error) {
+func (p *Provider) newSession(ctx context.Context, state *providerState, tr *TokenResponse) (*Session, error) {
 	newSession := &Session{
 		ProviderID: p.ID(),
+		Created:    time.Now(),
 	}
 	err := p.sessionSetupFunc(ctx, p, tr, newSession)
 	if err != nil {
 		return nil, err
 	}
+	// the id_token is only required for the logout if the provider
+	// has an end_session_endpoint
+	p.config.TokenRetention.apply(newSession, state.endpoints.EndSessionEndpoint != "")
 	return newSession, nil
 }
 
-func (p *Provider) intoTokenResponse(ctx context.Context, oauth2Token *oauth2.Token) (*TokenResponse, error) {
-	idToken, ok := oauth2Token.Extra("id_token").(string)
-	if !ok {
-		return nil, fmt.Errorf("inavlid type for id_token")
+// isOpenIDConnect returns true if the provider is an OpenID Connect provider
+// which returns an id_token. OAuth2-only providers have no issuer.
+func (p *Provider) isOpenIDConnect() bool {
+	return p.config.IssuerURL != ""
+}
+
+// intoTokenResponse verifies the id_token of the token response. OpenID
+// Connect providers have to return an id_token on the authorization code
+// exchange. On a refresh the id_token is optional. See
+// https://openid.net/specs/openid-connect-core-1_0.html#RefreshTokenResponse.
+func (p *Provider) intoTokenResponse(ctx context.Context, state *providerState, oauth2Token *oauth2.Token, refresh bool) (*TokenResponse, error) {
+	var idToken string
+	if rawIDToken := oauth2Token.Extra("id_token"); rawIDToken != nil {
+		var ok bool
+		idToken, ok = rawIDToken.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid type for id_token")
+		}
+	}
+
+	if p.config.TLSClientCertificateBoundAccessTokens {
+		err := p.verifyCertificateBinding(ctx, oauth2Token.AccessToken)
+		if err != nil {
+			return nil, fmt.Errorf("certificate binding verification failed: %w", err)
+		}
 	}
 
 	tokenResponse := &TokenResponse{
@@ -337,18 +786,17 @@ func (p *Provider) intoTokenResponse(ctx context.Context, oauth2Token *oauth2.To
 		RawIDToken: idToken,
 	}
 
-	// OAuth2 only
 	if idToken == "" {
+		// pure OAuth2 providers do not return an id_token at all
+		if p.isOpenIDConnect() && !refresh {
+			return nil, fmt.Errorf("id_token missing in token response")
+		}
 		return tokenResponse, nil
 	}
 
-	if p.oidcProvider == nil {
-		return tokenResponse, fmt.Errorf("failed to verify id_token: verifier not configured")
-	}
-
 	// Parse and verify ID Token payload.
 	var err error
-	tokenResponse.IDToken, err = p.oidcProvider.VerifierContext(ctx, p.oidcConfig).Verify(ctx, tokenResponse.RawIDToken)
+	tokenResponse.IDToken, err = p.verifyToken(ctx, state, *p.oidcConfig, tokenResponse.RawIDToken)
 	if err != nil {
 		return tokenResponse, fmt.Errorf("failed to verify id_token: %w", err)
 	}
@@ -383,6 +831,9 @@ func NewUserError(err error, code int, userErrorMessage string) *userError {
 type providerSet struct {
 	providerList []*Provider
 	providerMap  map[string]*Provider
+
+	// cancel stops the background discovery of the providers.
+	cancel context.CancelFunc
 }
 
 func NewProviderSet(ctx context.Context, providerConfigs []ProviderConfig, modifier func(pc *ProviderConfig)) ([]*Provider, error) {
@@ -417,6 +868,18 @@ func newProviderSet(providers ...*Provider) (*providerSet, error) {
 	}, nil
 }
 
+// Close stops the background discovery of the providers and waits until it
+// returned.
+func (ps *providerSet) Close() {
+	if ps.cancel == nil {
+		return
+	}
+	ps.cancel()
+	for _, p := range ps.providerList {
+		p.wait()
+	}
+}
+
 func (ps *providerSet) GetByID(id string) (*Provider, error) {
 	provider, ok := ps.providerMap[id]
 	if !ok {
@@ -428,3 +891,39 @@ func (ps *providerSet) GetByID(id string) (*Provider, error) {
 func (ps *providerSet) List() []*Provider {
 	return ps.providerList
 }
+
+// GetByEmail returns the first provider which has configured the domain of
+// the email address in its EmailDomains.
+func (ps *providerSet) GetByEmail(email string) (*Provider, bool) {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok || domain == "" {
+		return nil, false
+	}
+	for _, p := range ps.providerList {
+		if containsFold(p.config.EmailDomains, domain) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// GetByHost returns the first provider which has configured host in its
+// Hosts. The port of host is ignored if the configured hosts have no port.
+func (ps *providerSet) GetByHost(host string) (*Provider, bool) {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	for _, p := range ps.providerList {
+		if containsFold(p.config.Hosts, host) || containsFold(p.config.Hosts, hostname) {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(e string) bool {
+		return strings.EqualFold(e, s)
+	})
+}
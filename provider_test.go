@@ -0,0 +1,205 @@
+package oidcproxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	josejwt "github.com/go-jose/go-jose/v3/jwt"
+	"golang.org/x/oauth2"
+)
+
+// revocationServer records the requests to the revocation endpoint.
+type revocationServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	forms    []url.Values
+}
+
+func newRevocationServer(t *testing.T) *revocationServer {
+	rs := &revocationServer{}
+	// with TLS only the client of the server is able to connect
+	rs.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := r.ParseForm()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		rs.requests = append(rs.requests, r)
+		rs.forms = append(rs.forms, r.PostForm)
+		if r.PostForm.Get("token") == "invalid" {
+			http.Error(w, `{"error": "unsupported_token_type"}`, http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(rs.Close)
+	return rs
+}
+
+func TestRevoke(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		method ClientAuthMethod
+		verify func(r *http.Request, form url.Values) error
+	}{
+		{ClientSecretBasic, func(r *http.Request, form url.Values) error {
+			user, password, ok := r.BasicAuth()
+			if !ok || user != "client" || password != "secret" || form.Has("client_secret") {
+				return errors.New("expected client_secret_basic")
+			}
+			return nil
+		}},
+		{ClientSecretPost, func(r *http.Request, form url.Values) error {
+			if _, _, ok := r.BasicAuth(); ok || form.Get("client_id") != "client" || form.Get("client_secret") != "secret" {
+				return errors.New("expected client_secret_post")
+			}
+			return nil
+		}},
+		{PrivateKeyJWT, func(r *http.Request, form url.Values) error {
+			if form.Has("client_secret") || form.Get("client_assertion_type") != clientAssertionType {
+				return errors.New("expected private_key_jwt")
+			}
+			token, err := josejwt.ParseSigned(form.Get("client_assertion"))
+			if err != nil {
+				return err
+			}
+			claims := josejwt.Claims{}
+			err = token.Claims(&key.PublicKey, &claims)
+			if err != nil {
+				return err
+			}
+			return claims.Validate(josejwt.Expected{Issuer: "client", Subject: "client"})
+		}},
+	} {
+		t.Run(string(test.method), func(t *testing.T) {
+			rs := newRevocationServer(t)
+			p := &Provider{
+				id: "p1",
+				config: &ProviderConfig{
+					ClientID:     "client",
+					ClientSecret: "secret",
+					ClientKey:    key,
+				},
+				httpClient: rs.Client(),
+			}
+			p.state.Store(&providerState{
+				endpoints: Endpoints{
+					TokenEndpoint:      rs.URL + "/token",
+					RevocationEndpoint: rs.URL + "/revoke",
+				},
+				revocationAuthMethod: test.method,
+			})
+
+			session := &Session{Tokens: &Tokens{Token: oauth2.Token{AccessToken: "at", RefreshToken: "rt"}}}
+			err := p.RevokeSession(context.Background(), session)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the refresh token is revoked before the access token
+			if len(rs.forms) != 2 {
+				t.Fatalf("expected 2 revocation requests, got %d", len(rs.forms))
+			}
+			for i, expected := range []struct{ token, hint string }{{"rt", "refresh_token"}, {"at", "access_token"}} {
+				form := rs.forms[i]
+				if form.Get("token") != expected.token || form.Get("token_type_hint") != expected.hint {
+					t.Errorf("request %d: got token=%s token_type_hint=%s, want %s and %s", i, form.Get("token"), form.Get("token_type_hint"), expected.token, expected.hint)
+				}
+				err := test.verify(rs.requests[i], form)
+				if err != nil {
+					t.Errorf("request %d: %s", i, err)
+				}
+			}
+
+			// Revoke does not send a hint
+			err = p.Revoke(context.Background(), "at")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if form := rs.forms[2]; form.Get("token") != "at" || form.Has("token_type_hint") {
+				t.Errorf("unexpected revocation request %v", form)
+			}
+
+			err = p.Revoke(context.Background(), "invalid")
+			if err == nil {
+				t.Fatal("expected error for failed revocation")
+			}
+		})
+	}
+}
+
+func TestRevokeNotSupported(t *testing.T) {
+	p := &Provider{id: "p1", config: &ProviderConfig{}}
+	p.state.Store(&providerState{})
+	err := p.RevokeSession(context.Background(), &Session{})
+	if !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestTokenResponseWithoutIDToken(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"issuer":                 server.URL,
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+				"jwks_uri":               server.URL + "/keys",
+			})
+		case "/token":
+			_, _ = w.Write([]byte(`{"access_token": "at", "refresh_token": "rt", "token_type": "bearer", "expires_in": 60}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(context.Background(), ProviderConfig{
+		IssuerURL:    server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		HTTPClient:   server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the id_token is required on the code exchange
+	_, err = p.Exchange(context.Background(), "code")
+	if err == nil {
+		t.Fatal("expected error for token response without id_token")
+	}
+
+	// on a refresh the id_token is optional and the user is kept
+	session := &Session{
+		ProviderID: p.ID(),
+		Subject:    "user1",
+		User:       &User{ID: "user1", Name: "jane"},
+		Tokens:     &Tokens{Token: oauth2.Token{RefreshToken: "rt"}, IDToken: "id-token"},
+	}
+	refreshed, err := p.Refresh(context.Background(), session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Subject != "user1" || refreshed.User == nil || refreshed.User.Name != "jane" {
+		t.Fatalf("user not kept on refresh: %+v", refreshed)
+	}
+}
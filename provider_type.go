@@ -0,0 +1,225 @@
+package oidcproxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// ProviderType specifies the kind of a provider. Depending on the type
+// defaults for the endpoints and scopes are applied and the user is obtained
+// from the API of the provider.
+type ProviderType string
+
+const (
+	// ProviderTypeOIDC is an OpenID Connect provider. The user is read
+	// from the id_token. This is the default.
+	ProviderTypeOIDC ProviderType = "oidc"
+
+	// ProviderTypeOAuth2 is a generic OAuth2 provider without id_token.
+	// The user is read from the userinfo endpoint if it is configured.
+	ProviderTypeOAuth2 ProviderType = "oauth2"
+
+	// ProviderTypeGitHub, ProviderTypeGitLab and ProviderTypeBitbucket
+	// read the user, the emails and the memberships (organizations,
+	// teams, groups, workspaces) from the API of the provider.
+	ProviderTypeGitHub    ProviderType = "github"
+	ProviderTypeGitLab    ProviderType = "gitlab"
+	ProviderTypeBitbucket ProviderType = "bitbucket"
+
+	// ProviderTypeAzure, ProviderTypeGoogle, ProviderTypeKeycloak,
+	// ProviderTypeOkta and ProviderTypeAuth0 are OpenID Connect providers
+	// with presets for the issuer, the scopes and the claim mappings.
+	ProviderTypeAzure    ProviderType = "azure"
+	ProviderTypeGoogle   ProviderType = "google"
+	ProviderTypeKeycloak ProviderType = "keycloak"
+	ProviderTypeOkta     ProviderType = "okta"
+	ProviderTypeAuth0    ProviderType = "auth0"
+)
+
+// azureConsumersTenantID is the tenant of the personal Microsoft accounts.
+const azureConsumersTenantID = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+// defaultOIDCScopes are the scopes of the presets if not configured otherwise.
+var defaultOIDCScopes = []string{oidc.ScopeOpenID, "email", "profile", oidc.ScopeOfflineAccess}
+
+// providerTypeDefaults contains the defaults of the OAuth2-only provider
+// types.
+var providerTypeDefaults = map[ProviderType]struct {
+	endpoints Endpoints
+	apiURL    string
+	scopes    []string
+}{
+	ProviderTypeGitHub: {
+		endpoints: Endpoints{
+			AuthorizationEndpoint: "https://github.com/login/oauth/authorize",
+			TokenEndpoint:         "https://github.com/login/oauth/access_token",
+		},
+		apiURL: "https://api.github.com",
+		scopes: []string{"read:user", "user:email", "read:org"},
+	},
+	ProviderTypeGitLab: {
+		endpoints: Endpoints{
+			AuthorizationEndpoint: "https://gitlab.com/oauth/authorize",
+			TokenEndpoint:         "https://gitlab.com/oauth/token",
+			RevocationEndpoint:    "https://gitlab.com/oauth/revoke",
+		},
+		apiURL: "https://gitlab.com/api/v4",
+		scopes: []string{"read_user", "read_api"},
+	},
+	ProviderTypeBitbucket: {
+		endpoints: Endpoints{
+			AuthorizationEndpoint: "https://bitbucket.org/site/oauth2/authorize",
+			TokenEndpoint:         "https://bitbucket.org/site/oauth2/access_token",
+		},
+		apiURL: "https://api.bitbucket.org/2.0",
+		scopes: []string{"account", "email"},
+	},
+}
+
+// applyType validates the type and sets the defaults of the type for unset
+// settings.
+func (pc *ProviderConfig) applyType() error {
+	switch pc.Type {
+	case "", ProviderTypeOIDC:
+		return nil
+	case ProviderTypeOAuth2:
+		if pc.IssuerURL != "" {
+			return fmt.Errorf("issuer url is not supported for provider type %s", pc.Type)
+		}
+		return nil
+	case ProviderTypeAzure, ProviderTypeGoogle, ProviderTypeKeycloak, ProviderTypeOkta, ProviderTypeAuth0:
+		return pc.applyPreset()
+	}
+
+	defaults, ok := providerTypeDefaults[pc.Type]
+	if !ok {
+		return fmt.Errorf("unknown provider type '%s'", pc.Type)
+	}
+
+	if pc.IssuerURL != "" {
+		return fmt.Errorf("issuer url is not supported for provider type %s", pc.Type)
+	}
+
+	pc.Endpoints.Merge(&defaults.endpoints)
+	if pc.APIURL == "" {
+		pc.APIURL = defaults.apiURL
+	}
+	pc.APIURL = strings.TrimSuffix(pc.APIURL, "/")
+
+	if len(pc.Scopes) == 0 {
+		pc.Scopes = defaults.scopes
+	}
+	return nil
+}
+
+// applyPreset sets the defaults of the OpenID Connect presets. Explicitly
+// configured settings take precedence.
+func (pc *ProviderConfig) applyPreset() error {
+	scopes := defaultOIDCScopes
+	setDefault := func(value *string, defaultValue string) {
+		if *value == "" {
+			*value = defaultValue
+		}
+	}
+
+	switch pc.Type {
+	case ProviderTypeAzure:
+		if pc.IssuerURL == "" && pc.Tenant == "" {
+			return fmt.Errorf("provider type %s requires a tenant", pc.Type)
+		}
+		setDefault(&pc.IssuerURL, "https://login.microsoftonline.com/"+pc.Tenant+"/v2.0")
+		// the multi-tenant endpoints return the issuer template
+		// https://login.microsoftonline.com/{tenantid}/v2.0
+		switch pc.Tenant {
+		case "consumers":
+			// all personal accounts are issued by the same tenant
+			pc.SkipIssuerCheck = true
+			if len(pc.AllowedTenants) == 0 {
+				pc.AllowedTenants = []string{azureConsumersTenantID}
+			}
+		case "common", "organizations":
+			pc.SkipIssuerCheck = true
+			if len(pc.AllowedTenants) == 0 {
+				return fmt.Errorf("provider type %s with tenant %s requires allowed tenants (-allowed-tenants). use * to allow all tenants", pc.Type, pc.Tenant)
+			}
+		}
+		setDefault(&pc.UsernameClaim, "preferred_username")
+		setDefault(&pc.GroupsClaim, "groups")
+	case ProviderTypeGoogle:
+		setDefault(&pc.IssuerURL, "https://accounts.google.com")
+		// google does not support the offline_access scope
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+		pc.setDefaultAuthorizationParameter("access_type", "offline")
+		if pc.HostedDomain != "" {
+			pc.setDefaultAuthorizationParameter("hd", pc.HostedDomain)
+		}
+		setDefault(&pc.UsernameClaim, "email")
+	case ProviderTypeKeycloak:
+		if pc.IssuerURL == "" && (pc.BaseURL == "" || pc.Realm == "") {
+			return fmt.Errorf("provider type %s requires a base url and a realm", pc.Type)
+		}
+		setDefault(&pc.IssuerURL, strings.TrimSuffix(pc.BaseURL, "/")+"/realms/"+url.PathEscape(pc.Realm))
+		setDefault(&pc.UsernameClaim, "preferred_username")
+		setDefault(&pc.GroupsClaim, "realm_access.roles")
+	case ProviderTypeOkta:
+		if pc.IssuerURL == "" && pc.Domain == "" {
+			return fmt.Errorf("provider type %s requires a domain", pc.Type)
+		}
+		// the default custom authorization server
+		setDefault(&pc.IssuerURL, "https://"+pc.Domain+"/oauth2/default")
+		setDefault(&pc.UsernameClaim, "preferred_username")
+		setDefault(&pc.GroupsClaim, "groups")
+	case ProviderTypeAuth0:
+		if pc.IssuerURL == "" && pc.Domain == "" {
+			return fmt.Errorf("provider type %s requires a domain", pc.Type)
+		}
+		// the issuer of auth0 has a trailing slash
+		setDefault(&pc.IssuerURL, "https://"+pc.Domain+"/")
+		if pc.Audience != "" {
+			pc.setDefaultAuthorizationParameter("audience", pc.Audience)
+		}
+		setDefault(&pc.UsernameClaim, "email")
+	}
+
+	if len(pc.Scopes) == 0 {
+		pc.Scopes = scopes
+	}
+	return nil
+}
+
+// setDefaultAuthorizationParameter sets an authorization parameter if it is
+// not set yet.
+func (pc *ProviderConfig) setDefaultAuthorizationParameter(name, value string) {
+	if pc.AuthorizationParameter == nil {
+		pc.AuthorizationParameter = url.Values{}
+	}
+	if !pc.AuthorizationParameter.Has(name) {
+		pc.AuthorizationParameter.Set(name, value)
+	}
+}
+
+// typeSessionSetupFunc returns the SessionSetupFunc which obtains the user
+// for the provider type.
+func (pc *ProviderConfig) typeSessionSetupFunc() SessionSetupFunc {
+	switch pc.Type {
+	case ProviderTypeOAuth2:
+		return userinfoSessionSetupFunc
+	case ProviderTypeGitHub:
+		return githubSessionSetupFunc
+	case ProviderTypeGitLab:
+		return gitlabSessionSetupFunc
+	case ProviderTypeBitbucket:
+		return bitbucketSessionSetupFunc
+	case ProviderTypeGoogle:
+		if pc.HostedDomain == "" {
+			return nil
+		}
+		// the hd parameter is only a hint for the account selection
+		return RequireClaimValue("hd", pc.HostedDomain)
+	default:
+		return nil
+	}
+}
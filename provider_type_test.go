@@ -0,0 +1,119 @@
+package oidcproxy
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestApplyPreset(t *testing.T) {
+	for _, test := range []struct {
+		config        ProviderConfig
+		issuerURL     string
+		scopes        []string
+		groupsClaim   string
+		authParameter map[string]string
+	}{
+		{
+			config:      ProviderConfig{Type: ProviderTypeAzure, Tenant: "my-tenant"},
+			issuerURL:   "https://login.microsoftonline.com/my-tenant/v2.0",
+			scopes:      defaultOIDCScopes,
+			groupsClaim: "groups",
+		},
+		{
+			config:        ProviderConfig{Type: ProviderTypeGoogle, HostedDomain: "example.com"},
+			issuerURL:     "https://accounts.google.com",
+			scopes:        []string{"openid", "email", "profile"},
+			authParameter: map[string]string{"hd": "example.com", "access_type": "offline"},
+		},
+		{
+			config:      ProviderConfig{Type: ProviderTypeKeycloak, BaseURL: "https://sso.example.com/", Realm: "main"},
+			issuerURL:   "https://sso.example.com/realms/main",
+			scopes:      defaultOIDCScopes,
+			groupsClaim: "realm_access.roles",
+		},
+		{
+			config:        ProviderConfig{Type: ProviderTypeAuth0, Domain: "example.eu.auth0.com", Audience: "https://api.example.com"},
+			issuerURL:     "https://example.eu.auth0.com/",
+			scopes:        defaultOIDCScopes,
+			authParameter: map[string]string{"audience": "https://api.example.com"},
+		},
+		{
+			// explicit settings take precedence
+			config:      ProviderConfig{Type: ProviderTypeOkta, Domain: "example.okta.com", IssuerURL: "https://example.okta.com", Scopes: []string{"openid"}, GroupsClaim: "roles"},
+			issuerURL:   "https://example.okta.com",
+			scopes:      []string{"openid"},
+			groupsClaim: "roles",
+		},
+	} {
+		t.Run(string(test.config.Type), func(t *testing.T) {
+			config := test.config
+			err := config.applyType()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.IssuerURL != test.issuerURL {
+				t.Errorf("got issuer url '%s', want '%s'", config.IssuerURL, test.issuerURL)
+			}
+			if !slices.Equal(config.Scopes, test.scopes) {
+				t.Errorf("got scopes %v, want %v", config.Scopes, test.scopes)
+			}
+			if config.GroupsClaim != test.groupsClaim {
+				t.Errorf("got groups claim '%s', want '%s'", config.GroupsClaim, test.groupsClaim)
+			}
+			for name, value := range test.authParameter {
+				if actual := config.AuthorizationParameter.Get(name); actual != value {
+					t.Errorf("got authorization parameter %s='%s', want '%s'", name, actual, value)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyPresetAzureMultiTenant(t *testing.T) {
+	config := ProviderConfig{Type: ProviderTypeAzure, Tenant: "consumers"}
+	err := config.applyType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.SkipIssuerCheck || !slices.Equal(config.AllowedTenants, []string{azureConsumersTenantID}) {
+		t.Errorf("expected consumers tenant to be allowed, got %v", config.AllowedTenants)
+	}
+
+	for _, tenant := range []string{"common", "organizations"} {
+		config := ProviderConfig{Type: ProviderTypeAzure, Tenant: tenant}
+		err := config.applyType()
+		if err == nil || !strings.Contains(err.Error(), "-allowed-tenants") {
+			t.Errorf("%s: expected error which mentions -allowed-tenants, got %v", tenant, err)
+		}
+
+		config = ProviderConfig{Type: ProviderTypeAzure, Tenant: tenant, AllowedTenants: []string{"*"}}
+		err = config.applyType()
+		if err != nil || !config.SkipIssuerCheck {
+			t.Errorf("%s: unexpected error %v", tenant, err)
+		}
+	}
+}
+
+func TestLookupClaim(t *testing.T) {
+	claims := map[string]any{
+		"groups": []any{"a", "b"},
+		"realm_access": map[string]any{
+			"roles": []any{"admin", "user"},
+		},
+		"with.dot": "value",
+	}
+
+	if groups := claimStrings(lookupClaim(claims, "realm_access.roles")); !slices.Equal(groups, []string{"admin", "user"}) {
+		t.Errorf("unexpected roles %v", groups)
+	}
+	if groups := claimStrings(lookupClaim(claims, "groups")); !slices.Equal(groups, []string{"a", "b"}) {
+		t.Errorf("unexpected groups %v", groups)
+	}
+	if value := lookupClaim(claims, "with.dot"); value != "value" {
+		t.Errorf("unexpected value %v", value)
+	}
+	if value := lookupClaim(claims, "realm_access.missing.roles"); value != nil {
+		t.Errorf("expected nil, got %v", value)
+	}
+}
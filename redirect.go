@@ -0,0 +1,76 @@
+package oidcproxy
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// returnURLParameter is the query parameter of the login endpoint which
+// specifies where to redirect after a successful login.
+const returnURLParameter = "rd"
+
+// RedirectValidator validates the targets to which the user gets redirected
+// after a login. Relative URLs (paths) are always allowed. Absolute URLs are
+// only allowed if their scheme and host are allowed.
+type RedirectValidator struct {
+	// AllowedHosts contains the hosts to which redirects are allowed. A
+	// host with a leading dot (e.g. .example.com) allows the domain and
+	// all its subdomains. A host with a port only allows this port
+	// otherwise all ports are allowed.
+	AllowedHosts []string
+
+	// AllowedSchemes contains the schemes of absolute URLs to which
+	// redirects are allowed. Defaults to https.
+	AllowedSchemes []string
+}
+
+// Valid returns true if target is an allowed redirect target.
+func (rv *RedirectValidator) Valid(target string) bool {
+	if target == "" {
+		return false
+	}
+
+	// browsers treat backslashes like slashes and ignore some control
+	// characters which allows to sneak in a host (e.g. /\example.com)
+	if strings.ContainsAny(target, "\\\t\r\n") {
+		return false
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	if u.Scheme == "" && u.Host == "" {
+		return strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//")
+	}
+
+	if u.User != nil || u.Host == "" {
+		return false
+	}
+
+	allowedSchemes := rv.AllowedSchemes
+	if len(allowedSchemes) == 0 {
+		allowedSchemes = []string{"https"}
+	}
+	if !slices.Contains(allowedSchemes, strings.ToLower(u.Scheme)) {
+		return false
+	}
+
+	host := strings.ToLower(u.Host)
+	hostname := strings.ToLower(u.Hostname())
+	for _, allowedHost := range rv.AllowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+		if domain, ok := strings.CutPrefix(allowedHost, "."); ok {
+			if hostname == domain || strings.HasSuffix(hostname, allowedHost) {
+				return true
+			}
+			continue
+		}
+		if allowedHost == host || allowedHost == hostname {
+			return true
+		}
+	}
+	return false
+}
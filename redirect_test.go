@@ -0,0 +1,40 @@
+package oidcproxy
+
+import "testing"
+
+func TestRedirectValidator(t *testing.T) {
+	rv := &RedirectValidator{
+		AllowedHosts: []string{"app.example.com", ".example.org", "localhost:8080"},
+	}
+
+	for _, test := range []struct {
+		target string
+		valid  bool
+	}{
+		{"/", true},
+		{"/foo/bar?baz=1#x", true},
+		{"https://app.example.com/foo", true},
+		{"https://APP.example.com:8443/foo", true},
+		{"https://example.org/", true},
+		{"https://sub.example.org/", true},
+		{"https://localhost:8080/", true},
+		{"", false},
+		{"foo", false},
+		{"//evil.com", false},
+		{"/\\evil.com", false},
+		{"/\t/evil.com", false},
+		{"http://app.example.com/foo", false},
+		{"javascript:alert(1)", false},
+		{"https://evil.com", false},
+		{"https://app.example.com.evil.com/", false},
+		{"https://evilexample.org/", false},
+		{"https://app.example.com@evil.com/", false},
+		{"https://user@app.example.com/", false},
+		{"https://localhost:9090/", false},
+		{"https:/app.example.com", false},
+	} {
+		if valid := rv.Valid(test.target); valid != test.valid {
+			t.Errorf("target=%q: expected valid=%t, got %t", test.target, test.valid, valid)
+		}
+	}
+}
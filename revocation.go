@@ -0,0 +1,111 @@
+package oidcproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const defaultRevocationTTL = time.Hour * 24
+
+// RevocationStore keeps track of sessions which have been terminated at the
+// provider (e.g. by a back-channel logout). Since the sessions are stored in
+// cookies they can not be deleted directly. Instead each session is checked
+// against the store.
+// The default store keeps the revocations in memory. They are lost on a
+// restart and are not shared between multiple instances of the proxy. If
+// multiple instances serve the same sessions a shared store is required.
+// Otherwise a back-channel logout only terminates the sessions on the instance
+// which received the logout token.
+type RevocationStore interface {
+	// Revoke revokes all sessions of the provider which match sid or
+	// subject. Empty values are ignored.
+	Revoke(ctx context.Context, providerID, sid, subject string) error
+
+	// Revoked returns true if the session has been created before a
+	// matching revocation.
+	Revoked(ctx context.Context, s *Session) (bool, error)
+}
+
+type revocationKind int
+
+const (
+	revokeSID revocationKind = iota
+	revokeSubject
+)
+
+type revocationKey struct {
+	providerID string
+	kind       revocationKind
+	value      string
+}
+
+// revocationList is the in-memory RevocationStore. Sessions which have been
+// created before a matching revocation are considered revoked.
+// Entries are removed after ttl. Hence ttl should be longer than the lifetime
+// of a session.
+type revocationList struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[revocationKey]time.Time
+}
+
+func newRevocationList(ttl time.Duration) *revocationList {
+	return &revocationList{
+		ttl:     ttl,
+		entries: map[revocationKey]time.Time{},
+	}
+}
+
+// Revoke revokes all sessions of the provider which match sid or subject. Empty
+// values are ignored.
+func (rl *revocationList) Revoke(_ context.Context, providerID, sid, subject string) error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	rl.purge(now)
+
+	if sid != "" {
+		rl.entries[revocationKey{providerID, revokeSID, sid}] = now
+	}
+	if subject != "" {
+		rl.entries[revocationKey{providerID, revokeSubject, subject}] = now
+	}
+	return nil
+}
+
+// Revoked returns true if the session has been revoked.
+func (rl *revocationList) Revoked(_ context.Context, s *Session) (bool, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if len(rl.entries) == 0 {
+		return false, nil
+	}
+
+	for _, key := range []revocationKey{
+		{s.ProviderID, revokeSID, s.SID},
+		{s.ProviderID, revokeSubject, s.Subject},
+	} {
+		if key.value == "" {
+			continue
+		}
+		revoked, ok := rl.entries[key]
+		if !ok || time.Since(revoked) > rl.ttl {
+			continue
+		}
+		if !s.Created.After(revoked) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (rl *revocationList) purge(now time.Time) {
+	for key, revoked := range rl.entries {
+		if now.Sub(revoked) > rl.ttl {
+			delete(rl.entries, key)
+		}
+	}
+}
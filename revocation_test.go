@@ -0,0 +1,91 @@
+package oidcproxy
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRevocationList(t *testing.T) {
+	rl := newRevocationList(time.Hour)
+
+	before := time.Now().Add(-time.Minute)
+	_ = rl.Revoke(context.Background(), "p1", "sid1", "")
+	_ = rl.Revoke(context.Background(), "p1", "", "user2")
+	after := time.Now().Add(time.Minute)
+
+	for _, test := range []struct {
+		name    string
+		session *Session
+		revoked bool
+	}{
+		{"sid created before", &Session{ProviderID: "p1", SID: "sid1", Subject: "user1", Created: before}, true},
+		{"sid created after", &Session{ProviderID: "p1", SID: "sid1", Subject: "user1", Created: after}, false},
+		{"other sid", &Session{ProviderID: "p1", SID: "sid2", Subject: "user1", Created: before}, false},
+		{"sid of other provider", &Session{ProviderID: "p2", SID: "sid1", Subject: "user1", Created: before}, false},
+		{"subject created before", &Session{ProviderID: "p1", SID: "sid3", Subject: "user2", Created: before}, true},
+		{"subject created after", &Session{ProviderID: "p1", SID: "sid3", Subject: "user2", Created: after}, false},
+		{"subject without sid", &Session{ProviderID: "p1", Subject: "user2", Created: before}, true},
+		{"empty session", &Session{ProviderID: "p1", Created: before}, false},
+	} {
+		if revoked := revoked(t, rl, test.session); revoked != test.revoked {
+			t.Errorf("%s: got revoked=%t, want %t", test.name, revoked, test.revoked)
+		}
+	}
+}
+
+func TestRevocationListTTL(t *testing.T) {
+	rl := newRevocationList(time.Hour)
+	session := &Session{ProviderID: "p1", SID: "sid1", Created: time.Now().Add(-time.Hour * 3)}
+
+	// entries older than ttl are ignored
+	rl.entries[revocationKey{"p1", revokeSID, "sid1"}] = time.Now().Add(-time.Hour * 2)
+	if revoked(t, rl, session) {
+		t.Fatal("expired revocation applied")
+	}
+
+	// and purged on the next revocation
+	_ = rl.Revoke(context.Background(), "p1", "sid2", "")
+	if len(rl.entries) != 1 {
+		t.Fatalf("expected expired entry to be purged, got %v", rl.entries)
+	}
+	if _, ok := rl.entries[revocationKey{"p1", revokeSID, "sid2"}]; !ok {
+		t.Fatal("new entry missing")
+	}
+}
+
+func TestRevocationStoreError(t *testing.T) {
+	options := NewDefaultSessionManagerOptions()
+	options.RevocationStore = failingRevocationStore{}
+	sm := newTestSessionManager(t, options, newTestAuthProvider(&ProviderConfig{}))
+	session := &Session{ProviderID: "p1", SID: "sid1", Expiry: time.Now().Add(time.Hour)}
+
+	// sessions are rejected if the revocation can not be checked
+	r := newRequestWithCookies("/", sessionCookies(t, sm, session))
+	s, err := sm.GetSession(httptest.NewRecorder(), r)
+	if err == nil || s != nil {
+		t.Fatalf("expected error, got session %v", s)
+	}
+}
+
+type failingRevocationStore struct{}
+
+func (failingRevocationStore) Revoke(context.Context, string, string, string) error {
+	return errors.New("store unavailable")
+}
+
+func (failingRevocationStore) Revoked(context.Context, *Session) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+// revoked returns true if the session is revoked in the store.
+func revoked(t *testing.T, store RevocationStore, s *Session) bool {
+	t.Helper()
+	revoked, err := store.Revoked(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return revoked
+}
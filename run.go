@@ -1,6 +1,8 @@
 package oidcproxy
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -8,11 +10,18 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 )
 
+// shutdownTimeout is the time the server waits for active requests on
+// shutdown.
+const shutdownTimeout = time.Second * 10
+
 var (
 	version = "n/a"
 	commit  = "n/a"
@@ -20,33 +29,110 @@ var (
 
 func Run() error {
 	var (
-		defaultProvider = ProviderConfig{}
-		scopes          string
-		providerConfig  string
-		config          = NewDefaultConfig()
-		cookieHashKey   string
-		cookieEncKey    string
-		listenAddr      = "localhost:8080"
-		tlsCert         string
-		tlsKey          string
-		upstream        string
-		showVersion     bool
+		defaultProvider  = ProviderConfig{}
+		scopes           string
+		providerConfig   string
+		config           = NewDefaultConfig()
+		cookieHashKey    string
+		cookieEncKey     string
+		listenAddr       = "localhost:8080"
+		tlsCert          string
+		tlsKey           string
+		upstream         string
+		showVersion      bool
+		sessionBinding   string
+		bindingAction    string
+		cookieSameSite   = sameSiteString(config.CookieConfig.SameSite)
+		redirectHosts    string
+		redirectSchemes  = "https"
+		clientAuthMethod string
+		clientKeyFile    string
+		clientKeyID      string
+		clientAssertAlg  string
+		clientTLSCert    string
+		clientTLSKey     string
+		certBoundTokens  bool
+		providerCAFile   string
+		providerTimeout  time.Duration
+		providerProxyURL string
+		deferDiscovery   bool
+		rediscovery      time.Duration
+		providerType     string
+		allowedGroups    string
+		allowedTenants   string
+		passthrough      string
+		parMode          string
+		stepUpRoutes     string
+		acrLevels        string
 	)
 
 	// proxy options
 	flag.StringVar(&defaultProvider.IssuerURL, "issuer-url", defaultProvider.IssuerURL, "oidc issuer url")
 	flag.StringVar(&defaultProvider.ClientID, "client-id", defaultProvider.ClientID, "client id")
+	flag.StringVar(&providerType, "provider-type", providerType, "provider type (oidc, oauth2, github, gitlab, bitbucket, azure, google, keycloak, okta, auth0)")
+	flag.StringVar(&defaultProvider.Tenant, "provider-tenant", defaultProvider.Tenant, "tenant of the azure provider")
+	flag.StringVar(&defaultProvider.Domain, "provider-domain", defaultProvider.Domain, "domain of the okta and auth0 provider (e.g. example.okta.com)")
+	flag.StringVar(&defaultProvider.BaseURL, "provider-base-url", defaultProvider.BaseURL, "base url of the keycloak provider")
+	flag.StringVar(&defaultProvider.Realm, "provider-realm", defaultProvider.Realm, "realm of the keycloak provider")
+	flag.StringVar(&defaultProvider.HostedDomain, "provider-hosted-domain", defaultProvider.HostedDomain, "google workspace domain to which the login of the google provider is restricted")
+	flag.StringVar(&defaultProvider.Audience, "provider-audience", defaultProvider.Audience, "audience of the access tokens of the auth0 provider")
+	flag.StringVar(&defaultProvider.UsernameClaim, "username-claim", defaultProvider.UsernameClaim, "id_token claim which is used as user name")
+	flag.StringVar(&defaultProvider.GroupsClaim, "groups-claim", defaultProvider.GroupsClaim, "id_token claim which is used as groups. nested claims are separated by dots (e.g. realm_access.roles)")
+	flag.BoolVar(&defaultProvider.SkipIssuerCheck, "skip-issuer-check", defaultProvider.SkipIssuerCheck, "skip the issuer check of the discovery and verify the issuer of the tokens against the issuer template and the allowed tenants instead (e.g. for the azure common endpoint)")
+	flag.StringVar(&defaultProvider.IssuerTemplate, "issuer-template", defaultProvider.IssuerTemplate, "template of the token issuer. {tenantid} matches the tenant. defaults to the discovered issuer")
+	flag.StringVar(&allowedTenants, "allowed-tenants", allowedTenants, "a comma-seperated list of tenants which are allowed if the issuer check is skipped. use * to allow all tenants")
+	flag.StringVar(&defaultProvider.APIURL, "api-url", defaultProvider.APIURL, "base url of the provider api for the provider types github, gitlab and bitbucket (e.g. for GitHub Enterprise)")
+	flag.StringVar(&allowedGroups, "allowed-groups", allowedGroups, "a comma-seperated list of groups of which a user has to be a member to login (e.g. github organizations or teams in the form org/team)")
+	flag.StringVar(&passthrough, "passthrough-parameters", passthrough, "a comma-seperated list of authorization parameters which are forwarded from the login request to the provider (e.g. "+strings.Join(DefaultPassthroughParameters, ",")+")")
+	flag.BoolVar(&defaultProvider.RequestObject, "request-object", defaultProvider.RequestObject, "send the authorization parameters as signed request object. it is signed with the client key or the client secret")
+	flag.StringVar(&defaultProvider.ResponseMode, "response-mode", defaultProvider.ResponseMode, "response mode of the authorization response (query, form_post, jwt, query.jwt, form_post.jwt). the jwt modes require a signed authorization response (JARM)")
+	flag.StringVar(&parMode, "par", parMode, "use pushed authorization requests (auto, always, never). auto uses them if the provider supports them and falls back to the url if they fail (default auto)")
 	flag.StringVar(&defaultProvider.ClientSecret, "client-secret", defaultProvider.ClientSecret, "client secret id")
+	flag.StringVar(&clientAuthMethod, "client-auth-method", clientAuthMethod, "client authentication method (client_secret_basic, client_secret_post, client_secret_jwt, private_key_jwt, none). if not set it is selected based on the provider metadata")
+	flag.StringVar(&clientKeyFile, "client-key-file", clientKeyFile, "pem encoded private key to sign the client assertions of the private_key_jwt client authentication")
+	flag.StringVar(&clientKeyID, "client-key-id", clientKeyID, "key id (kid) of the client key")
+	flag.StringVar(&clientAssertAlg, "client-assertion-alg", clientAssertAlg, "algorithm to sign the client assertions (e.g. RS256, ES256, HS256). if not set it is derived from the key")
+	flag.StringVar(&clientTLSCert, "client-tls-cert", clientTLSCert, "tls client certificate which is presented to the provider (mutual-TLS client authentication)")
+	flag.StringVar(&clientTLSKey, "client-tls-key", clientTLSKey, "key of the tls client certificate")
+	flag.BoolVar(&certBoundTokens, "client-cert-bound-tokens", certBoundTokens, "require access tokens which are bound to the tls client certificate")
+	flag.StringVar(&providerCAFile, "provider-ca-file", providerCAFile, "pem bundle of additional certificate authorities to verify the provider")
+	flag.DurationVar(&providerTimeout, "provider-timeout", providerTimeout, "timeout of the requests to the provider (default 30s)")
+	flag.StringVar(&providerProxyURL, "provider-proxy-url", providerProxyURL, "url of the http proxy for the requests to the provider. if not set the proxy is read from the environment")
+	flag.BoolVar(&deferDiscovery, "defer-discovery", deferDiscovery, "perform the provider discovery in the background and retry it until it succeeds instead of failing on startup")
+	flag.DurationVar(&rediscovery, "rediscovery-interval", rediscovery, "interval in which the provider discovery is repeated to pick up changed endpoints and keys. if not set the discovery is only performed once")
 	defaultScopes := []string{oidc.ScopeOpenID, "email", "profile", oidc.ScopeOfflineAccess}
 	flag.StringVar(&scopes, "scopes", strings.Join(defaultScopes, ","), "a comma-seperated list of scopes")
 
 	flag.StringVar(&providerConfig, "provider-config", providerConfig, "provider config file")
 
 	flag.StringVar(&config.CallbackURL, "callback-url", config.CallbackURL, "callback URL")
-	flag.StringVar(&config.PostLogoutRediretURI, "post-logout-url", config.PostLogoutRediretURI, "post logout redirect uri")
+	flag.StringVar(&config.PostLogoutRediretURI, "post-logout-url", config.PostLogoutRediretURI, "post logout redirect uri (defaults to the post logout path on the host of the callback url)")
 	flag.StringVar(&cookieHashKey, "cookie-hash-key", cookieHashKey, "cookie hash key")
 	flag.StringVar(&cookieEncKey, "cookie-enc-key", cookieEncKey, "cookie encryption key")
 	flag.BoolVar(&config.CookieConfig.Secure, "cookie-secure", config.CookieConfig.Secure, "set cookie secure setting")
+	flag.StringVar(&config.CookieConfig.Domain, "cookie-domain", config.CookieConfig.Domain, "cookie domain")
+	flag.StringVar(&config.CookieConfig.Path, "cookie-path", config.CookieConfig.Path, "cookie path")
+	flag.StringVar(&cookieSameSite, "cookie-samesite", cookieSameSite, "cookie SameSite setting (default, lax, strict, none)")
+	flag.DurationVar(&config.CookieConfig.Duration, "cookie-max-age", config.CookieConfig.Duration, "cookie max age. if not set session cookies are used")
+	flag.StringVar(&config.SessionCookieName, "cookie-name", config.SessionCookieName, "name of the session cookie")
+	flag.StringVar(&config.LoginStateCookieName, "cookie-state-name", config.LoginStateCookieName, "name of the login state cookie")
+	flag.DurationVar(&config.LoginStateTTL, "login-state-ttl", config.LoginStateTTL, "duration after which a pending login expires")
+	flag.StringVar(&config.LastProviderCookieName, "cookie-provider-name", config.LastProviderCookieName, "name of the cookie which remembers the provider of the last login")
+	flag.DurationVar(&config.LastProviderTTL, "last-provider-ttl", config.LastProviderTTL, "duration for which the provider of the last login is remembered to skip the provider selection. 0 disables it")
+	flag.DurationVar(&config.RevocationTTL, "revocation-ttl", config.RevocationTTL, "duration for which sessions terminated by a back-channel logout are remembered (in memory)")
+	flag.StringVar(&config.CookiePrefix, "cookie-prefix", config.CookiePrefix, "prefix for the cookie names (__Host- or __Secure-)")
+
+	flag.StringVar(&sessionBinding, "session-binding", sessionBinding, "a comma-seperated list of client attributes to which a session gets bound (user-agent, ip, tls-client-cert)")
+	flag.IntVar(&config.SessionBinding.IPv4PrefixLength, "session-binding-ipv4-prefix", config.SessionBinding.IPv4PrefixLength, "prefix length of the ipv4 network to which a session gets bound")
+	flag.IntVar(&config.SessionBinding.IPv6PrefixLength, "session-binding-ipv6-prefix", config.SessionBinding.IPv6PrefixLength, "prefix length of the ipv6 network to which a session gets bound")
+	flag.StringVar(&bindingAction, "session-binding-action", string(config.SessionBinding.Action), "action on a session binding mismatch (reject, login, log)")
+
+	flag.BoolVar(&config.SilentLogin, "silent-login", config.SilentLogin, "renew expired sessions without refresh token using a login with prompt=none before an interactive login is initiated")
+	flag.StringVar(&stepUpRoutes, "step-up-routes", stepUpRoutes, "a comma-seperated list of path prefixes which require a step-up authentication in the form <path-prefix>=acr:<value> or <path-prefix>=amr:<value> (e.g. /admin=acr:mfa,/payments=amr:otp)")
+	flag.StringVar(&acrLevels, "acr-levels", acrLevels, "a comma-seperated list of acr values ordered from the weakest to the strongest. a stronger acr satisfies step-up routes which require a weaker one")
+
+	flag.StringVar(&redirectHosts, "redirect-allowed-hosts", redirectHosts, "a comma-seperated list of hosts to which a redirect after the login is allowed. a leading dot allows all subdomains (e.g. .example.com)")
+	flag.StringVar(&redirectSchemes, "redirect-allowed-schemes", redirectSchemes, "a comma-seperated list of schemes to which a redirect after the login is allowed")
 
 	flag.StringVar(&config.TemplateDir, "template-dir", config.TemplateDir, "template dir to overwrite existing templates")
 	flag.BoolVar(&config.TemplateDevMode, "template-dev-mode", config.TemplateDevMode, "reload templates on each request")
@@ -84,12 +170,37 @@ func Run() error {
 	}
 
 	if defaultProvider.ClientID != "" {
-		defaultProvider.Scopes = strings.Split(scopes, ",")
+		defaultProvider.Type = ProviderType(providerType)
+		defaultProvider.AllowedGroups = splitList(allowedGroups)
+		defaultProvider.AllowedTenants = splitList(allowedTenants)
+		defaultProvider.PassthroughParameters = splitList(passthrough)
+		defaultProvider.PushedAuthorizationRequests = PARMode(parMode)
+		// the default scopes are meant for OpenID Connect providers.
+		// other provider types have their own defaults.
+		if defaultProvider.Type == "" || defaultProvider.Type == ProviderTypeOIDC || scopes != strings.Join(defaultScopes, ",") {
+			defaultProvider.Scopes = strings.Split(scopes, ",")
+		}
+		defaultProvider.ClientAuthMethod = ClientAuthMethod(clientAuthMethod)
+		defaultProvider.ClientKeyFile = clientKeyFile
+		defaultProvider.ClientKeyID = clientKeyID
+		defaultProvider.ClientAssertionAlgorithm = clientAssertAlg
+		defaultProvider.HTTPClientConfig.TLSClientCertFile = clientTLSCert
+		defaultProvider.HTTPClientConfig.TLSClientKeyFile = clientTLSKey
+		defaultProvider.TLSClientCertificateBoundAccessTokens = certBoundTokens
+		defaultProvider.HTTPClientConfig.CAFile = providerCAFile
+		defaultProvider.HTTPClientConfig.Timeout = Duration(providerTimeout)
+		defaultProvider.HTTPClientConfig.ProxyURL = providerProxyURL
 		providers = append(providers, defaultProvider)
 	}
 
 	for i := range providers {
 		providers[i].SetupSessionFunc = ChainSessionSetupFunc(SaveGroups())
+		if deferDiscovery {
+			providers[i].DeferDiscovery = true
+		}
+		if providers[i].RediscoveryInterval == 0 {
+			providers[i].RediscoveryInterval = Duration(rediscovery)
+		}
 	}
 
 	if len(providers) == 0 {
@@ -100,6 +211,26 @@ func Run() error {
 		slog.Info("configured provider", "client_id", p.ClientID, "issuer_url", p.IssuerURL, "name", p.Name)
 	}
 
+	err = config.SessionBinding.SetAttributes(sessionBinding)
+	if err != nil {
+		return err
+	}
+	config.SessionBinding.Action = SessionBindingAction(bindingAction)
+
+	err = config.StepUp.SetRoutes(stepUpRoutes)
+	if err != nil {
+		return err
+	}
+	config.StepUp.ACRLevels = splitList(acrLevels)
+
+	config.CookieConfig.SameSite, err = ParseSameSite(cookieSameSite)
+	if err != nil {
+		return err
+	}
+
+	config.RedirectAllowedHosts = splitList(redirectHosts)
+	config.RedirectAllowedSchemes = splitList(redirectSchemes)
+
 	config.HashKey = []byte(cookieHashKey)
 	config.EncryptKey = []byte(cookieEncKey)
 	config.Providers = providers
@@ -121,24 +252,54 @@ func Run() error {
 		})
 	}
 
-	authenticated, err := NewMainHandler(config, inner)
+	app, err := NewApp(config)
 	if err != nil {
 		return err
 	}
+	defer app.Close()
+
+	authenticated := app.NewAuthHandler(inner)
 
 	//authenticated := authenticator.Handler(inner)
 
 	logger := newLogHandler(authenticated)
 
+	server := &http.Server{
+		Addr:    listenAddr,
+		Handler: logger,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		slog.Info("shutdown server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdownErr <- server.Shutdown(shutdownCtx)
+	}()
+
 	if tlsCert != "" || tlsKey != "" {
 		listenURL := fmt.Sprintf("https://%s/", listenAddr)
 		slog.Info("run server", "addr", listenURL)
-		return http.ListenAndServeTLS(listenAddr, tlsCert, tlsKey, logger)
+		if config.SessionBinding.TLSClientCert {
+			// the certificate is only used to bind the session
+			// hence we do not verify it.
+			server.TLSConfig = &tls.Config{
+				ClientAuth: tls.RequestClientCert,
+			}
+		}
+		err = server.ListenAndServeTLS(tlsCert, tlsKey)
 	} else {
 		listenURL := fmt.Sprintf("http://%s/", listenAddr)
 		slog.Info("run server", "addr", listenURL)
-		return http.ListenAndServe(listenAddr, logger)
+		err = server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return <-shutdownErr
 }
 
 // readFlagFromEnv reads settings from environment into a FlagSet. This should
@@ -165,6 +326,18 @@ func readFlagFromEnv(fs *flag.FlagSet, prefix string) error {
 	return errors.Join(errs...)
 }
 
+// splitList splits a comma-seperated list and removes empty elements.
+func splitList(list string) []string {
+	elements := []string{}
+	for _, element := range strings.Split(list, ",") {
+		element = strings.TrimSpace(element)
+		if element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
 func readProviders(file string) ([]ProviderConfig, error) {
 	rawFile, err := os.ReadFile(file)
 	if err != nil {
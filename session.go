@@ -23,6 +23,41 @@ type Session struct {
 	// session setup which helps to keep the cookie small.
 	Tokens *Tokens `json:"tokens,omitempty"`
 
+	// IDTokenHint is the id_token which is kept to send it as
+	// id_token_hint on logout if the TokenRetentionPolicy drops the
+	// id_token from Tokens.
+	IDTokenHint string `json:"id_token_hint,omitempty"`
+
+	// Subject is the subject (sub claim) of the id_token. It is kept even
+	// if the id_token gets dropped from the session (see
+	// TokenRetentionPolicy) to match back-channel logout tokens.
+	Subject string `json:"sub,omitempty"`
+
+	// SID is the session ID (sid claim) of the id_token. It identifies
+	// the session at the provider and is used for the back-channel and
+	// front-channel logout.
+	SID string `json:"sid,omitempty"`
+
+	// AuthTime is the time when the user authenticated at the provider
+	// (auth_time claim of the id_token). It is only set if the provider
+	// returns the claim.
+	AuthTime time.Time `json:"auth_time,omitempty"`
+
+	// ACR and AMR are the authentication context class reference and the
+	// authentication methods (acr and amr claims of the id_token). They
+	// are used to decide if a step-up authentication is required (see
+	// StepUpConfig).
+	ACR string   `json:"acr,omitempty"`
+	AMR []string `json:"amr,omitempty"`
+
+	// Created is the time of the login which initiated the session. It
+	// does not change on a token refresh.
+	Created time.Time `json:"created,omitempty"`
+
+	// Binding contains the attributes of the client to which the session
+	// is bound (see SessionBindingConfig).
+	Binding *SessionBinding `json:"binding,omitempty"`
+
 	// User represents the authenticated user. The user can be initialized
 	// during the session setup. Usually for this values from the claims in
 	// the id_token are used.
@@ -41,6 +76,40 @@ type User struct {
 	Extra  any      `json:"extra,omitempty"`
 }
 
+// carryOver copies values from a previous session which are not necessarily
+// available again on a token refresh.
+func (s *Session) carryOver(previous *Session) {
+	if s.Subject == "" {
+		s.Subject = previous.Subject
+	}
+	if s.SID == "" {
+		s.SID = previous.SID
+	}
+	if s.AuthTime.IsZero() {
+		s.AuthTime = previous.AuthTime
+	}
+	if s.ACR == "" {
+		s.ACR = previous.ACR
+	}
+	if s.AMR == nil {
+		s.AMR = previous.AMR
+	}
+	if !previous.Created.IsZero() {
+		s.Created = previous.Created
+	}
+	if s.Binding == nil {
+		s.Binding = previous.Binding
+	}
+	// OpenID Connect providers do not necessarily return an id_token on a
+	// refresh. without id_token the user can not be set up again.
+	if s.User == nil {
+		s.User = previous.User
+	}
+	if s.idTokenHint() == "" {
+		s.IDTokenHint = previous.idTokenHint()
+	}
+}
+
 func (s *Session) Valid() bool {
 	if s == nil {
 		return false
@@ -98,3 +167,11 @@ func (s *Session) IDToken() string {
 	}
 	return s.Tokens.IDToken
 }
+
+// idTokenHint returns the id_token which is sent as id_token_hint on logout.
+func (s *Session) idTokenHint() string {
+	if s.HasIDToken() {
+		return s.IDToken()
+	}
+	return s.IDTokenHint
+}
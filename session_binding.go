@@ -0,0 +1,174 @@
+package oidcproxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ErrSessionBindingMismatch is returned by GetSession if the session is bound
+// to another client and the SessionBindingConfig requires to reject the
+// request.
+var ErrSessionBindingMismatch = errors.New("session binding mismatch")
+
+// SessionBindingAction specifies what happens if a session is used by a
+// client which does not match the client the session is bound to.
+type SessionBindingAction string
+
+const (
+	// SessionBindingReject rejects the request.
+	SessionBindingReject SessionBindingAction = "reject"
+	// SessionBindingLogin removes the session which leads to a new login.
+	SessionBindingLogin SessionBindingAction = "login"
+	// SessionBindingLog only logs the mismatch.
+	SessionBindingLog SessionBindingAction = "log"
+)
+
+// SessionBindingConfig configures to which attributes of a client a session
+// gets bound. The attributes are recorded on login and verified on each
+// subsequent request. This prevents that a stolen session cookie can be used
+// from another client.
+type SessionBindingConfig struct {
+	// UserAgent binds the session to a hash of the User-Agent header.
+	UserAgent bool
+
+	// IP binds the session to the network prefix of the remote address.
+	// The prefix lengths specify the size of the network. Be aware that
+	// the remote address of the connection is used. If the proxy runs
+	// behind another proxy this is the address of the other proxy.
+	IP               bool
+	IPv4PrefixLength int
+	IPv6PrefixLength int
+
+	// TLSClientCert binds the session to the fingerprint of the TLS
+	// client certificate.
+	TLSClientCert bool
+
+	// Action specifies what to do if the binding does not match. Defaults
+	// to SessionBindingReject.
+	Action SessionBindingAction
+}
+
+func NewDefaultSessionBindingConfig() SessionBindingConfig {
+	return SessionBindingConfig{
+		IPv4PrefixLength: 32,
+		IPv6PrefixLength: 64,
+		Action:           SessionBindingReject,
+	}
+}
+
+func (sbc *SessionBindingConfig) Enabled() bool {
+	return sbc.UserAgent || sbc.IP || sbc.TLSClientCert
+}
+
+func (sbc *SessionBindingConfig) Validate() error {
+	switch sbc.Action {
+	case "", SessionBindingReject, SessionBindingLogin, SessionBindingLog:
+	default:
+		return fmt.Errorf("invalid session binding action '%s'", sbc.Action)
+	}
+	if sbc.IP {
+		if sbc.IPv4PrefixLength < 0 || sbc.IPv4PrefixLength > 32 {
+			return fmt.Errorf("invalid ipv4 prefix length %d", sbc.IPv4PrefixLength)
+		}
+		if sbc.IPv6PrefixLength < 0 || sbc.IPv6PrefixLength > 128 {
+			return fmt.Errorf("invalid ipv6 prefix length %d", sbc.IPv6PrefixLength)
+		}
+	}
+	return nil
+}
+
+// SetAttributes enables the binding attributes from a comma separated list
+// (user-agent, ip, tls-client-cert).
+func (sbc *SessionBindingConfig) SetAttributes(attributes string) error {
+	for _, attribute := range strings.Split(attributes, ",") {
+		switch strings.TrimSpace(attribute) {
+		case "":
+		case "user-agent":
+			sbc.UserAgent = true
+		case "ip":
+			sbc.IP = true
+		case "tls-client-cert":
+			sbc.TLSClientCert = true
+		default:
+			return fmt.Errorf("unknown session binding attribute '%s'", attribute)
+		}
+	}
+	return nil
+}
+
+// SessionBinding contains the attributes of the client to which the session
+// is bound.
+type SessionBinding struct {
+	UserAgent     string `json:"ua,omitempty"`
+	IPPrefix      string `json:"ip,omitempty"`
+	TLSClientCert string `json:"cert,omitempty"`
+}
+
+// newSessionBinding records the attributes of the client of r.
+func (sbc *SessionBindingConfig) newSessionBinding(r *http.Request) *SessionBinding {
+	sb := &SessionBinding{}
+	if sbc.UserAgent {
+		sb.UserAgent = hashString(r.UserAgent())
+	}
+	if sbc.IP {
+		sb.IPPrefix = sbc.ipPrefix(r.RemoteAddr)
+	}
+	if sbc.TLSClientCert && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		fingerprint := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		sb.TLSClientCert = base64.RawURLEncoding.EncodeToString(fingerprint[:])
+	}
+	return sb
+}
+
+// mismatches returns the names of the attributes which differ between the
+// binding of the session and the client of r.
+func (sbc *SessionBindingConfig) mismatches(r *http.Request, sb *SessionBinding) []string {
+	if sb == nil {
+		return []string{"missing"}
+	}
+	current := sbc.newSessionBinding(r)
+	mismatches := []string{}
+	if sbc.UserAgent && current.UserAgent != sb.UserAgent {
+		mismatches = append(mismatches, "user-agent")
+	}
+	if sbc.IP && current.IPPrefix != sb.IPPrefix {
+		mismatches = append(mismatches, "ip")
+	}
+	if sbc.TLSClientCert && current.TLSClientCert != sb.TLSClientCert {
+		mismatches = append(mismatches, "tls-client-cert")
+	}
+	return mismatches
+}
+
+func (sbc *SessionBindingConfig) ipPrefix(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	bits := sbc.IPv6PrefixLength
+	if addr.Is4() {
+		bits = sbc.IPv4PrefixLength
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+func hashString(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return base64.RawURLEncoding.EncodeToString(hash[:16])
+}
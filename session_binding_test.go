@@ -0,0 +1,98 @@
+package oidcproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionBinding(t *testing.T) {
+	provider := &Provider{id: "p1", config: &ProviderConfig{}}
+
+	newRequest := func(userAgent, remoteAddr string, cert []byte) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("User-Agent", userAgent)
+		r.RemoteAddr = remoteAddr
+		if cert != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Raw: cert}}}
+		}
+		return r
+	}
+	loginRequest := func() *http.Request {
+		return newRequest("agent1", "192.0.2.10:1234", []byte("cert1"))
+	}
+
+	for _, test := range []struct {
+		name     string
+		request  *http.Request
+		mismatch bool
+	}{
+		{"same client", loginRequest(), false},
+		{"same ip prefix", newRequest("agent1", "192.0.2.20:4321", []byte("cert1")), false},
+		{"user-agent mismatch", newRequest("agent2", "192.0.2.10:1234", []byte("cert1")), true},
+		{"ip prefix mismatch", newRequest("agent1", "198.51.100.10:1234", []byte("cert1")), true},
+		{"cert mismatch", newRequest("agent1", "192.0.2.10:1234", []byte("cert2")), true},
+		{"cert missing", newRequest("agent1", "192.0.2.10:1234", nil), true},
+	} {
+		for _, action := range []SessionBindingAction{SessionBindingReject, SessionBindingLogin, SessionBindingLog} {
+			options := NewDefaultSessionManagerOptions()
+			options.SessionBinding = SessionBindingConfig{
+				UserAgent:        true,
+				IP:               true,
+				IPv4PrefixLength: 24,
+				IPv6PrefixLength: 64,
+				TLSClientCert:    true,
+				Action:           action,
+			}
+			sm := newTestSessionManager(t, options, provider)
+
+			s := &Session{ProviderID: provider.ID()}
+			sm.BindSession(loginRequest(), s)
+
+			r := test.request.Clone(test.request.Context())
+			for _, c := range sessionCookies(t, sm, s) {
+				r.AddCookie(c)
+			}
+			recorder := httptest.NewRecorder()
+			sessionCtx, err := sm.GetSession(recorder, r)
+
+			removed := len(recorder.Result().Cookies()) > 0
+			switch {
+			case !test.mismatch || action == SessionBindingLog:
+				if err != nil || sessionCtx == nil || removed {
+					t.Errorf("%s (%s): expected session, got %v (err=%v, removed=%t)", test.name, action, sessionCtx, err, removed)
+				}
+			case action == SessionBindingLogin:
+				if err != nil || sessionCtx != nil || !removed {
+					t.Errorf("%s (%s): expected removed session without error, got %v (err=%v, removed=%t)", test.name, action, sessionCtx, err, removed)
+				}
+			default:
+				if !errors.Is(err, ErrSessionBindingMismatch) || sessionCtx != nil || !removed {
+					t.Errorf("%s (%s): expected binding mismatch error, got %v (err=%v, removed=%t)", test.name, action, sessionCtx, err, removed)
+				}
+			}
+		}
+	}
+}
+
+func TestSessionBindingIPPrefix(t *testing.T) {
+	sbc := &SessionBindingConfig{IP: true, IPv4PrefixLength: 24, IPv6PrefixLength: 64}
+	for _, test := range []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"192.0.2.10:1234", "192.0.2.0/24"},
+		{"192.0.2.10", "192.0.2.0/24"},
+		{"[::ffff:192.0.2.10]:1234", "192.0.2.0/24"},
+		{"[2001:db8:1:2:3::1]:1234", "2001:db8:1:2::/64"},
+		{"invalid", ""},
+	} {
+		prefix := sbc.ipPrefix(test.remoteAddr)
+		if prefix != test.expected {
+			t.Errorf("%s: got '%s', want '%s'", test.remoteAddr, prefix, test.expected)
+		}
+	}
+}
@@ -19,25 +19,29 @@ type PathSet struct {
 }
 
 type SessionInfoTemplateData struct {
-	Session  *Session
-	Provider *Provider
-	Path     PathSet
+	Session   *Session
+	Provider  *Provider
+	Path      PathSet
+	CSRFToken string
 }
 
 func NewDefaultSessionInfoHandler(sm *sessionManager, tm *templateManager, pathSet PathSet) http.Handler {
 	renderSessionHandler := func(w http.ResponseWriter, r *http.Request, s *SessionContext) {
 		var (
-			session  *Session
-			provider *Provider
+			session   *Session
+			provider  *Provider
+			csrfToken string
 		)
 		if s != nil {
 			session = s.Session
 			provider = s.Provider
+			csrfToken = sm.CSRFToken(s.Session)
 		}
 		data := &SessionInfoTemplateData{
-			Session:  session,
-			Provider: provider,
-			Path:     pathSet,
+			Session:   session,
+			Provider:  provider,
+			Path:      pathSet,
+			CSRFToken: csrfToken,
 		}
 		tm.servePage(w, "session_info_new", data)
 
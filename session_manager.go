@@ -5,18 +5,72 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"strings"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	defaultLoginStateTTL   = time.Minute * 10
+	defaultLastProviderTTL = time.Hour * 24 * 30
 )
 
 type sessionManager struct {
-	cookieHandler        *CookieHandler
-	sessionCookieName    string
-	loginStateCookieName string
-	providerSet          *providerSet
-	logger               *slog.Logger
+	cookieHandler          *CookieHandler
+	sessionCookieName      string
+	loginStateCookieName   string
+	lastProviderCookieName string
+	providerSet            *providerSet
+	loginStateTTL          time.Duration
+	lastProviderTTL        time.Duration
+	sessionBinding         SessionBindingConfig
+	stepUp                 StepUpConfig
+	silentLogin            bool
+	revocations            RevocationStore
+	csrfKey                []byte
+	logger                 *slog.Logger
+}
+
+// SessionManagerOptions configures the cookies and the login behavior of the
+// session manager.
+type SessionManagerOptions struct {
+	SessionCookieName      string
+	LoginStateCookieName   string
+	LastProviderCookieName string
+
+	// LoginStateTTL is the duration after which a pending login expires.
+	LoginStateTTL time.Duration
+
+	// LastProviderTTL is the duration for which the provider of the last
+	// login is remembered. If it is zero the provider is not remembered.
+	LastProviderTTL time.Duration
+
+	// RevocationTTL is the duration for which revoked sessions are
+	// remembered by the default in-memory RevocationStore.
+	RevocationTTL time.Duration
+
+	// RevocationStore stores the sessions terminated by a back-channel
+	// logout. If not set the revocations are kept in memory.
+	RevocationStore RevocationStore
+
+	SessionBinding SessionBindingConfig
+	StepUp         StepUpConfig
+	SilentLogin    bool
+}
+
+func NewDefaultSessionManagerOptions() SessionManagerOptions {
+	return SessionManagerOptions{
+		SessionCookieName:      "oprox",
+		LoginStateCookieName:   "oprox_state",
+		LastProviderCookieName: "oprox_provider",
+		LoginStateTTL:          defaultLoginStateTTL,
+		LastProviderTTL:        defaultLastProviderTTL,
+		RevocationTTL:          defaultRevocationTTL,
+		SessionBinding:         NewDefaultSessionBindingConfig(),
+	}
 }
 
-func NewSessionManager(hashKey, encryptionKey []byte, providerSet *providerSet, cookieOptions CookieOptions) (*sessionManager, error) {
+func NewSessionManager(hashKey, encryptionKey []byte, providerSet *providerSet, cookieOptions CookieOptions, options SessionManagerOptions) (*sessionManager, error) {
 	if !(len(hashKey) == 32 || len(hashKey) == 64) {
 		return nil, fmt.Errorf("hash key is missing or has invalid key length. a length of 32 or 64 is required")
 	}
@@ -25,12 +79,24 @@ func NewSessionManager(hashKey, encryptionKey []byte, providerSet *providerSet,
 	}
 
 	cookieHandler := NewCookieHandlerWithOptions(hashKey, encryptionKey, cookieOptions)
+	revocations := options.RevocationStore
+	if revocations == nil {
+		revocations = newRevocationList(options.RevocationTTL)
+	}
 	return &sessionManager{
-		cookieHandler:        cookieHandler,
-		loginStateCookieName: "oprox_state",
-		sessionCookieName:    "oprox",
-		providerSet:          providerSet,
-		logger:               slog.Default(),
+		cookieHandler:          cookieHandler,
+		loginStateCookieName:   options.LoginStateCookieName,
+		sessionCookieName:      options.SessionCookieName,
+		lastProviderCookieName: options.LastProviderCookieName,
+		loginStateTTL:          options.LoginStateTTL,
+		lastProviderTTL:        options.LastProviderTTL,
+		sessionBinding:         options.SessionBinding,
+		stepUp:                 options.StepUp,
+		silentLogin:            options.SilentLogin,
+		revocations:            revocations,
+		csrfKey:                newCSRFKey(hashKey),
+		providerSet:            providerSet,
+		logger:                 slog.Default(),
 	}, nil
 }
 
@@ -68,6 +134,20 @@ func (sm *sessionManager) GetSession(w http.ResponseWriter, r *http.Request) (*S
 		sm.RemoveSession(w, r)
 		return nil, err
 	}
+	revoked, err := sm.revocations.Revoked(r.Context(), s)
+	if err != nil {
+		sm.logger.Warn("failed to check session revocation", "err", err)
+		return nil, err
+	}
+	if revoked {
+		sm.logger.Info("session revoked", "provider_id", s.ProviderID, "sid", s.SID, "sub", s.Subject)
+		sm.RemoveSession(w, r)
+		return nil, nil
+	}
+	ok, err = sm.verifyBinding(w, r, s)
+	if !ok {
+		return nil, err
+	}
 	return &SessionContext{
 		Session:  s,
 		Provider: provider,
@@ -87,56 +167,238 @@ func (sm *sessionManager) SetSession(w http.ResponseWriter, r *http.Request, s *
 	return nil
 }
 
+// BindSession binds the session to the client of the request if a session
+// binding is configured.
+func (sm *sessionManager) BindSession(r *http.Request, s *Session) {
+	if !sm.sessionBinding.Enabled() {
+		return
+	}
+	s.Binding = sm.sessionBinding.newSessionBinding(r)
+}
+
+// verifyBinding checks if the client of the request matches the binding of
+// the session. On a mismatch the session is removed and ok is false unless the
+// configured action is to only log the mismatch. If the request has to be
+// rejected ErrSessionBindingMismatch is returned.
+func (sm *sessionManager) verifyBinding(w http.ResponseWriter, r *http.Request, s *Session) (ok bool, err error) {
+	if !sm.sessionBinding.Enabled() {
+		return true, nil
+	}
+	mismatches := sm.sessionBinding.mismatches(r, s.Binding)
+	if len(mismatches) == 0 {
+		return true, nil
+	}
+
+	action := sm.sessionBinding.Action
+	if action == "" {
+		action = SessionBindingReject
+	}
+	var userID string
+	if s.User != nil {
+		userID = s.User.ID
+	}
+	sm.logger.Warn("session binding mismatch",
+		"audit", true,
+		"action", action,
+		"attributes", mismatches,
+		"provider_id", s.ProviderID,
+		"user_id", userID,
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.UserAgent(),
+	)
+
+	switch action {
+	case SessionBindingLog:
+		return true, nil
+	case SessionBindingLogin:
+		sm.RemoveSession(w, r)
+		return false, nil
+	default:
+		sm.RemoveSession(w, r)
+		return false, ErrSessionBindingMismatch
+	}
+}
+
 func (sm *sessionManager) RemoveSession(w http.ResponseWriter, r *http.Request) {
 	sm.cookieHandler.Delete(w, r, sm.sessionCookieName)
 }
 
+// RemoveCookie removes the session and login state cookies from the request.
 func (sm *sessionManager) RemoveCookie(r *http.Request) {
 	cookies := r.Cookies()
 	r.Header.Del("Cookie")
 	for _, c := range cookies {
-		if c.Name == sm.sessionCookieName || strings.HasPrefix(c.Name, sm.sessionCookieName+"_") {
+		if sm.isOwnCookie(c.Name) {
 			continue
 		}
 		r.AddCookie(c)
 	}
 }
 
+func (sm *sessionManager) isOwnCookie(name string) bool {
+	for _, ownName := range []string{sm.sessionCookieName, sm.loginStateCookieName, sm.lastProviderCookieName} {
+		if name == ownName || isCookiePart(name, ownName) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetLastProvider returns the provider which was used for the last successful
+// login. It returns nil if the last provider is not remembered or no longer
+// configured.
+func (sm *sessionManager) GetLastProvider(r *http.Request) *Provider {
+	if sm.lastProviderTTL <= 0 {
+		return nil
+	}
+	var providerID string
+	ok, err := sm.cookieHandler.Get(r, sm.lastProviderCookieName, &providerID)
+	if !ok || err != nil {
+		return nil
+	}
+	provider, err := sm.providerSet.GetByID(providerID)
+	if err != nil {
+		return nil
+	}
+	return provider
+}
+
+// SetLastProvider remembers the provider of a successful login so that
+// returning users skip the provider selection. The cookie outlives the
+// session and expires after lastProviderTTL.
+func (sm *sessionManager) SetLastProvider(w http.ResponseWriter, r *http.Request, providerID string) {
+	if sm.lastProviderTTL <= 0 || len(sm.providerSet.List()) < 2 {
+		return
+	}
+	err := sm.cookieHandler.Set(w, r, sm.lastProviderCookieName, providerID, func(c *http.Cookie) {
+		c.Expires = time.Now().Add(sm.lastProviderTTL)
+		c.MaxAge = int(sm.lastProviderTTL.Seconds())
+	})
+	if err != nil {
+		sm.logger.Error("failed to encode last provider", "err", err)
+	}
+}
+
+// RemoveLastProvider forgets the provider of the last login.
+func (sm *sessionManager) RemoveLastProvider(w http.ResponseWriter, r *http.Request) {
+	sm.cookieHandler.Delete(w, r, sm.lastProviderCookieName)
+}
+
+// maxPendingLogins limits the number of login states which are stored in the
+// login state cookie. If a new login is started and the limit is reached the
+// oldest login state is removed.
+const maxPendingLogins = 8
+
+// LoginState is the state of a login flow. Multiple login flows (e.g. in
+// different tabs) can be pending at the same time. They are distinguished by
+// State. The state of a RP initiated logout is stored as LoginState as well
+// with Logout set to true.
 type LoginState struct {
 	ProviderID string
 	State      string
 	URI        string
+	Created    time.Time
+	Logout     bool `json:",omitempty"`
+
+	// MaxAge is the max_age in seconds which was requested for the login.
+	MaxAge *int `json:",omitempty"`
+
+	// ACRValues are the acr_values which were requested by a step-up
+	// authentication. The acr of the new session has to be one of them.
+	ACRValues string `json:",omitempty"`
+
+	// AMR are the authentication methods which are required by a step-up
+	// authentication.
+	AMR []string `json:",omitempty"`
+
+	// Silent is set for a login with prompt=none. If the provider
+	// requires an interaction the login is restarted interactively.
+	Silent bool `json:",omitempty"`
+
+	// Parameters are the authorization parameters which were passed
+	// through from the login request (e.g. login_hint).
+	Parameters url.Values `json:",omitempty"`
 }
 
-func (sm *sessionManager) GetLoginState(w http.ResponseWriter, r *http.Request) *LoginState {
-	loginState := &LoginState{}
-	ok, err := sm.cookieHandler.Get(r, sm.loginStateCookieName, loginState)
+// GetLoginState returns the pending login state which matches state or nil if
+// there is none.
+func (sm *sessionManager) GetLoginState(w http.ResponseWriter, r *http.Request, state string) *LoginState {
+	for _, loginState := range sm.getLoginStates(w, r) {
+		if loginState.State == state {
+			return loginState
+		}
+	}
+	return nil
+}
+
+// SetLoginState adds the login state to the pending login states. An existing
+// login state with the same State gets replaced.
+func (sm *sessionManager) SetLoginState(w http.ResponseWriter, r *http.Request, l *LoginState) error {
+	return sm.ReplaceLoginState(w, r, l.State, l)
+}
+
+// ReplaceLoginState replaces the login state which matches state by l (e.g.
+// if a login gets restarted with a new state).
+func (sm *sessionManager) ReplaceLoginState(w http.ResponseWriter, r *http.Request, state string, l *LoginState) error {
+	if l.Created.IsZero() {
+		l.Created = time.Now()
+	}
+	loginStates := sm.getLoginStates(w, r)
+	loginStates = slices.DeleteFunc(loginStates, func(existing *LoginState) bool {
+		return existing.State == state || existing.State == l.State
+	})
+	loginStates = append(loginStates, l)
+	if len(loginStates) > maxPendingLogins {
+		loginStates = loginStates[len(loginStates)-maxPendingLogins:]
+	}
+	return sm.setLoginStates(w, r, loginStates)
+}
+
+// DeleteLoginState removes the login state which matches state.
+func (sm *sessionManager) DeleteLoginState(w http.ResponseWriter, r *http.Request, state string) {
+	loginStates := sm.getLoginStates(w, r)
+	loginStates = slices.DeleteFunc(loginStates, func(existing *LoginState) bool {
+		return existing.State == state
+	})
+	_ = sm.setLoginStates(w, r, loginStates)
+}
+
+// getLoginStates returns the login states from the login state cookie which
+// are not expired.
+func (sm *sessionManager) getLoginStates(w http.ResponseWriter, r *http.Request) []*LoginState {
+	loginStates := []*LoginState{}
+	ok, err := sm.cookieHandler.Get(r, sm.loginStateCookieName, &loginStates)
 	if !ok {
 		return nil
 	}
 	if err != nil {
 		slog.Info("failed to decode login state", "err", err)
-		sm.DeleteLoginState(w, r)
+		sm.cookieHandler.Delete(w, r, sm.loginStateCookieName)
 		return nil
 	}
-	return loginState
+	return slices.DeleteFunc(loginStates, func(l *LoginState) bool {
+		return l == nil || time.Since(l.Created) > sm.loginStateTTL
+	})
 }
 
-func (sm *sessionManager) SetLoginState(w http.ResponseWriter, r *http.Request, l *LoginState) error {
-	err := sm.cookieHandler.Set(w, r, sm.loginStateCookieName, l)
+func (sm *sessionManager) setLoginStates(w http.ResponseWriter, r *http.Request, loginStates []*LoginState) error {
+	if len(loginStates) == 0 {
+		sm.cookieHandler.Delete(w, r, sm.loginStateCookieName)
+		return nil
+	}
+	err := sm.cookieHandler.Set(w, r, sm.loginStateCookieName, loginStates)
 	if err != nil {
 		slog.Error("failed to encode login state", "err", err)
 	}
 	return err
 }
 
-func (sm *sessionManager) DeleteLoginState(w http.ResponseWriter, r *http.Request) {
-	sm.cookieHandler.Delete(w, r, sm.loginStateCookieName)
-}
-
 type contextKey int
 
-const sessionContextKey contextKey = 0
+const (
+	sessionContextKey contextKey = iota
+	loginStateContextKey
+)
 
 func SessionFromContext(ctx context.Context) *SessionContext {
 	s, _ := ctx.Value(sessionContextKey).(*SessionContext)
@@ -146,3 +408,14 @@ func SessionFromContext(ctx context.Context) *SessionContext {
 func ContextWithSession(parent context.Context, s *SessionContext) context.Context {
 	return context.WithValue(parent, sessionContextKey, s)
 }
+
+// LoginStateFromContext returns the login state of the callback which is
+// currently processed.
+func LoginStateFromContext(ctx context.Context) *LoginState {
+	l, _ := ctx.Value(loginStateContextKey).(*LoginState)
+	return l
+}
+
+func ContextWithLoginState(parent context.Context, l *LoginState) context.Context {
+	return context.WithValue(parent, loginStateContextKey, l)
+}
@@ -0,0 +1,126 @@
+package oidcproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestLoginStates(t *testing.T) {
+	options := NewDefaultSessionManagerOptions()
+	options.LoginStateTTL = time.Minute
+	sm := newTestSessionManager(t, options)
+
+	// cookies keeps the login state cookie across the requests
+	var cookies []*http.Cookie
+	newRequest := func() *http.Request {
+		return newRequestWithCookies("/", cookies)
+	}
+	setLoginState := func(l *LoginState) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		err := sm.SetLoginState(recorder, newRequest(), l)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cookies = recorder.Result().Cookies()
+	}
+	states := func() []string {
+		states := []string{}
+		for _, l := range sm.getLoginStates(httptest.NewRecorder(), newRequest()) {
+			states = append(states, l.State)
+		}
+		return states
+	}
+
+	// expired login states are filtered
+	setLoginState(&LoginState{State: "expired", Created: time.Now().Add(-time.Minute * 2)})
+	setLoginState(&LoginState{State: "state1", URI: "/first"})
+	if got := fmt.Sprint(states()); got != "[state1]" {
+		t.Fatalf("expected only state1, got %s", got)
+	}
+	if l := sm.GetLoginState(httptest.NewRecorder(), newRequest(), "expired"); l != nil {
+		t.Fatal("expired login state returned")
+	}
+
+	// a login state with the same state gets replaced
+	setLoginState(&LoginState{State: "state1", URI: "/second"})
+	if got := fmt.Sprint(states()); got != "[state1]" {
+		t.Fatalf("expected replaced state1, got %s", got)
+	}
+	if l := sm.GetLoginState(httptest.NewRecorder(), newRequest(), "state1"); l == nil || l.URI != "/second" {
+		t.Fatalf("expected replaced login state, got %+v", l)
+	}
+
+	// the oldest login states are evicted after maxPendingLogins
+	for i := 2; i <= maxPendingLogins+1; i++ {
+		setLoginState(&LoginState{State: fmt.Sprintf("state%d", i)})
+	}
+	got := states()
+	if len(got) != maxPendingLogins || got[0] != "state2" || got[len(got)-1] != fmt.Sprintf("state%d", maxPendingLogins+1) {
+		t.Fatalf("expected states 2 to %d, got %v", maxPendingLogins+1, got)
+	}
+
+	// deleting the last login state removes the cookie
+	for _, state := range got {
+		recorder := httptest.NewRecorder()
+		sm.DeleteLoginState(recorder, newRequest(), state)
+		cookies = recorder.Result().Cookies()
+	}
+	if len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Fatalf("expected login state cookie to be deleted, got %v", cookies)
+	}
+}
+
+// newTestSessionManager returns a session manager with the default cookie
+// options for the providers.
+func newTestSessionManager(t *testing.T, options SessionManagerOptions, providers ...*Provider) *sessionManager {
+	t.Helper()
+	ps, err := newProviderSet(providers...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm, err := NewSessionManager(make([]byte, 32), nil, ps, NewDefaultCookieOptions(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sm
+}
+
+// newTestAuthProvider returns a provider with the ID p1 which sends logins to
+// the authorization endpoint https://idp.example.com/authorize.
+func newTestAuthProvider(config *ProviderConfig) *Provider {
+	provider := &Provider{id: "p1", config: config}
+	provider.state.Store(&providerState{
+		oauth2Config: &oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{AuthURL: "https://idp.example.com/authorize"},
+		},
+	})
+	return provider
+}
+
+// sessionCookies returns the cookies which the session manager sets for the
+// session s.
+func sessionCookies(t *testing.T, sm *sessionManager, s *Session) []*http.Cookie {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	err := sm.SetSession(recorder, httptest.NewRequest(http.MethodGet, "/", nil), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recorder.Result().Cookies()
+}
+
+// newRequestWithCookies returns a GET request for target with the cookies.
+func newRequestWithCookies(target string, cookies []*http.Cookie) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
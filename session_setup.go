@@ -50,13 +50,26 @@ var defaultSessionSetupFunc SessionSetupFunc = func(ctx context.Context, p *Prov
 		return nil
 	}
 
+	s.Subject = t.IDToken.Subject
+
 	claims := struct {
-		EMail             string `json:"email"`
-		PreferredUsername string `json:"preferred_username"`
+		EMail             string   `json:"email"`
+		PreferredUsername string   `json:"preferred_username"`
+		SID               string   `json:"sid"`
+		AuthTime          int64    `json:"auth_time"`
+		ACR               string   `json:"acr"`
+		AMR               []string `json:"amr"`
 	}{}
 
 	_ = t.IDToken.Claims(&claims)
 
+	s.SID = claims.SID
+	if claims.AuthTime != 0 {
+		s.AuthTime = time.Unix(claims.AuthTime, 0)
+	}
+	s.ACR = claims.ACR
+	s.AMR = claims.AMR
+
 	s.User = &User{
 		ID:   t.IDToken.Subject,
 		Name: claims.EMail,
@@ -136,6 +149,117 @@ func RequireIDTokenGroup(groups ...string) SessionSetupFunc {
 	}
 }
 
+// RequireGroup verifies that the user of the session is member of at least
+// one of the given groups. Unlike RequireIDTokenGroup it uses the groups of
+// the user which can also originate from other sources (e.g. the GitHub
+// API).
+func RequireGroup(groups ...string) SessionSetupFunc {
+	return func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+		if s.User != nil {
+			for _, group := range groups {
+				if slices.Contains(s.User.Groups, group) {
+					return nil
+				}
+			}
+		}
+		message := fmt.Sprintf("You are not authorized. You need to be a member of one of theses groups: %s", strings.Join(groups, ", "))
+		return NewUserError(nil, 401, message)
+	}
+}
+
+// RequireClaimValue verifies that the id_token contains the claim with the
+// given value.
+func RequireClaimValue(claim, value string) SessionSetupFunc {
+	return func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+		if t.IDToken == nil {
+			return fmt.Errorf("id token missing to check claim %s", claim)
+		}
+
+		claims := map[string]any{}
+		err := t.IDToken.Claims(&claims)
+		if err != nil {
+			return err
+		}
+
+		if actual, _ := lookupClaim(claims, claim).(string); actual != value {
+			return NewUserError(fmt.Errorf("claim %s is '%s' instead of '%s'", claim, actual, value), 401, "You are not authorized.")
+		}
+		return nil
+	}
+}
+
+// MapClaims sets the name and the groups of the user from the given id_token
+// claims. Nested claims are separated by dots (e.g. realm_access.roles). Empty
+// claim names are ignored.
+func MapClaims(usernameClaim, groupsClaim string) SessionSetupFunc {
+	return func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+		if t.IDToken == nil {
+			return nil
+		}
+
+		claims := map[string]any{}
+		err := t.IDToken.Claims(&claims)
+		if err != nil {
+			return nil
+		}
+
+		if s.User == nil {
+			s.User = &User{
+				ID: t.IDToken.Subject,
+			}
+		}
+
+		if usernameClaim != "" {
+			if name, ok := lookupClaim(claims, usernameClaim).(string); ok && name != "" {
+				s.User.Name = name
+			}
+		}
+
+		if groupsClaim != "" {
+			s.User.Groups = claimStrings(lookupClaim(claims, groupsClaim))
+		}
+		return nil
+	}
+}
+
+// lookupClaim returns the value of a claim. Nested claims are separated by
+// dots. If the claim does not exist nil is returned.
+func lookupClaim(claims map[string]any, name string) any {
+	// prefer claims which contain a dot in their name
+	if value, ok := claims[name]; ok {
+		return value
+	}
+
+	var value any = claims
+	for _, part := range strings.Split(name, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[part]
+	}
+	return value
+}
+
+// claimStrings turns a claim which is either a string or a list of strings
+// into a list of strings.
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := []string{}
+		for _, element := range v {
+			if s, ok := element.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
 type ClaimCheckFunc func(claims map[string]any) error
 
 func NewSessionClaimCheckFunc(claimCheckFunc ClaimCheckFunc) SessionSetupFunc {
@@ -0,0 +1,163 @@
+package oidcproxy
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// StepUpRoute requires a stronger authentication for all paths starting with
+// PathPrefix. The session satisfies the route if its acr is at least ACR and
+// it contains all of the authentication methods in AMR.
+type StepUpRoute struct {
+	PathPrefix string   `json:"path_prefix"`
+	ACR        string   `json:"acr,omitempty"`
+	AMR        []string `json:"amr,omitempty"`
+}
+
+// StepUpConfig configures the routes which require a step-up authentication.
+// If a session does not satisfy the route of a request, a new login is
+// initiated with the parameters acr_values and prompt=login.
+type StepUpConfig struct {
+	Routes []StepUpRoute
+
+	// ACRLevels lists the acr values of the provider from the weakest to
+	// the strongest. A session with a stronger acr satisfies a route which
+	// requires a weaker one. If not set the acr has to match exactly.
+	ACRLevels []string
+}
+
+func (suc *StepUpConfig) Validate() error {
+	for _, route := range suc.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return fmt.Errorf("invalid step-up path prefix '%s'", route.PathPrefix)
+		}
+		if route.ACR == "" && len(route.AMR) == 0 {
+			return fmt.Errorf("step-up route '%s' requires neither acr nor amr", route.PathPrefix)
+		}
+		if route.ACR != "" && len(suc.ACRLevels) > 0 && !slices.Contains(suc.ACRLevels, route.ACR) {
+			return fmt.Errorf("acr '%s' of step-up route '%s' is not one of the acr levels", route.ACR, route.PathPrefix)
+		}
+	}
+	return nil
+}
+
+// SetRoutes parses a comma-separated list of requirements of the form
+// <path-prefix>=acr:<value> or <path-prefix>=amr:<value>. Multiple
+// requirements for the same path prefix are combined.
+func (suc *StepUpConfig) SetRoutes(routes string) error {
+	for _, requirement := range strings.Split(routes, ",") {
+		requirement = strings.TrimSpace(requirement)
+		if requirement == "" {
+			continue
+		}
+		pathPrefix, claimValue, ok := strings.Cut(requirement, "=")
+		if !ok {
+			return fmt.Errorf("invalid step-up route '%s'", requirement)
+		}
+		claim, value, ok := strings.Cut(claimValue, ":")
+		if !ok || value == "" {
+			return fmt.Errorf("invalid step-up route '%s'", requirement)
+		}
+
+		i := slices.IndexFunc(suc.Routes, func(r StepUpRoute) bool { return r.PathPrefix == pathPrefix })
+		if i < 0 {
+			suc.Routes = append(suc.Routes, StepUpRoute{PathPrefix: pathPrefix})
+			i = len(suc.Routes) - 1
+		}
+		switch claim {
+		case "acr":
+			suc.Routes[i].ACR = value
+		case "amr":
+			suc.Routes[i].AMR = append(suc.Routes[i].AMR, value)
+		default:
+			return fmt.Errorf("invalid step-up claim '%s' in '%s'. allowed claims are acr and amr", claim, requirement)
+		}
+	}
+	return nil
+}
+
+// route returns the route with the longest path prefix which matches path.
+// The path prefix only matches whole path segments (e.g. /admin matches
+// /admin and /admin/users but not /administrator).
+func (suc *StepUpConfig) route(path string) *StepUpRoute {
+	var match *StepUpRoute
+	for i, route := range suc.Routes {
+		if !hasPathPrefix(path, route.PathPrefix) {
+			continue
+		}
+		if match == nil || len(route.PathPrefix) > len(match.PathPrefix) {
+			match = &suc.Routes[i]
+		}
+	}
+	return match
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// satisfied reports whether the session satisfies the route.
+func (suc *StepUpConfig) satisfied(route *StepUpRoute, s *Session) bool {
+	for _, amr := range route.AMR {
+		if !slices.Contains(s.AMR, amr) {
+			return false
+		}
+	}
+	if route.ACR == "" || s.ACR == route.ACR {
+		return true
+	}
+	required := slices.Index(suc.ACRLevels, route.ACR)
+	current := slices.Index(suc.ACRLevels, s.ACR)
+	return required >= 0 && current > required
+}
+
+// acrValues returns the acr_values which are requested for the route. With
+// ACRLevels the required acr and all stronger ones are requested.
+func (suc *StepUpConfig) acrValues(route *StepUpRoute) string {
+	if route.ACR == "" {
+		return ""
+	}
+	required := slices.Index(suc.ACRLevels, route.ACR)
+	if required < 0 {
+		return route.ACR
+	}
+	return strings.Join(suc.ACRLevels[required:], " ")
+}
+
+// loginState returns the login state of a step-up authentication for the
+// route. The requested acr_values and the required amr are verified on the
+// callback.
+func (suc *StepUpConfig) loginState(route *StepUpRoute) *LoginState {
+	return &LoginState{
+		ACRValues: suc.acrValues(route),
+		AMR:       slices.Clone(route.AMR),
+	}
+}
+
+// verifyACR checks if the acr of the session is one of the requested
+// acr_values. Since acr_values is only a voluntary claim request the provider
+// might authenticate the user with a weaker method. Without this check such
+// a login would initiate the step-up again.
+func verifyACR(s *Session, acrValues string) error {
+	if !slices.Contains(strings.Fields(acrValues), s.ACR) {
+		return fmt.Errorf("acr '%s' does not match the requested acr_values '%s'", s.ACR, acrValues)
+	}
+	return nil
+}
+
+// verifyAMR checks if the session contains all of the required authentication
+// methods. Like the acr the amr can not be enforced by the authentication
+// request. Without this check a login without the methods would initiate the
+// step-up again.
+func verifyAMR(s *Session, amr []string) error {
+	for _, method := range amr {
+		if !slices.Contains(s.AMR, method) {
+			return fmt.Errorf("amr %v does not contain the required method '%s'", s.AMR, method)
+		}
+	}
+	return nil
+}
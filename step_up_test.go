@@ -0,0 +1,62 @@
+package oidcproxy
+
+import "testing"
+
+func TestStepUpConfig(t *testing.T) {
+	suc := &StepUpConfig{
+		ACRLevels: []string{"pwd", "mfa", "hwk"},
+	}
+	err := suc.SetRoutes("/admin=acr:mfa,/admin/keys=amr:hwk,/admin/keys=acr:mfa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = suc.Validate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		path      string
+		acr       string
+		amr       []string
+		satisfied bool
+	}{
+		{"/", "", nil, true},
+		{"/admin", "pwd", nil, false},
+		{"/admin/", "pwd", nil, false},
+		{"/administrator", "pwd", nil, true},
+		{"/admin/keysafe", "mfa", nil, true},
+		{"/admin/users", "mfa", nil, true},
+		{"/admin/users", "hwk", nil, true},
+		{"/admin/keys", "mfa", []string{"pwd", "otp"}, false},
+		{"/admin/keys", "mfa", []string{"pwd", "hwk"}, true},
+	} {
+		satisfied := true
+		if route := suc.route(test.path); route != nil {
+			satisfied = suc.satisfied(route, &Session{ACR: test.acr, AMR: test.amr})
+		}
+		if satisfied != test.satisfied {
+			t.Errorf("%s with acr=%s amr=%v: got %t, want %t", test.path, test.acr, test.amr, satisfied, test.satisfied)
+		}
+	}
+
+	loginState := suc.loginState(suc.route("/admin/keys"))
+	if loginState.ACRValues != "mfa hwk" || len(loginState.AMR) != 1 || loginState.AMR[0] != "hwk" {
+		t.Errorf("unexpected login state: %+v", loginState)
+	}
+
+	if verifyAMR(&Session{AMR: []string{"pwd"}}, loginState.AMR) == nil {
+		t.Error("expected error for missing amr")
+	}
+	if verifyAMR(&Session{AMR: []string{"pwd", "hwk"}}, loginState.AMR) != nil {
+		t.Error("unexpected error for required amr")
+	}
+
+	if verifyACR(&Session{ACR: "pwd"}, "mfa hwk") == nil {
+		t.Error("expected error for insufficient acr")
+	}
+
+	if (&StepUpConfig{Routes: []StepUpRoute{{PathPrefix: "/admin"}}}).Validate() == nil {
+		t.Error("expected error for route without requirements")
+	}
+}
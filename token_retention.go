@@ -0,0 +1,123 @@
+package oidcproxy
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+)
+
+// TokenRetention specifies if a token is kept in the session.
+type TokenRetention string
+
+const (
+	// TokenKeep keeps the token in the session. This is the default.
+	TokenKeep TokenRetention = "keep"
+	// TokenDrop removes the token from the session.
+	TokenDrop TokenRetention = "drop"
+)
+
+func (tr TokenRetention) drop() bool {
+	return tr == TokenDrop
+}
+
+func (tr TokenRetention) validate() error {
+	switch tr {
+	case "", TokenKeep, TokenDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid token retention '%s'. allowed values are '%s' and '%s'", tr, TokenKeep, TokenDrop)
+	}
+}
+
+// TokenRetentionPolicy specifies which tokens are kept in the session. The
+// policy is applied after the session setup. Tokens which are not required
+// (e.g. by the upstream) should be dropped to keep the session cookie small.
+//
+// If the refresh_token is dropped no refresh is possible and the
+// offline_access scope is no longer requested.
+// The id_token is sent as id_token_hint for the RP initiated logout. If the
+// id_token is dropped and the provider has an end_session_endpoint, it is kept
+// apart from the tokens in Session.IDTokenHint. It is only removed completely
+// to meet MaxBytes. In this case the logout falls back to send the client_id
+// which some providers do not accept without a confirmation.
+type TokenRetentionPolicy struct {
+	AccessToken  TokenRetention `json:"access_token,omitempty"`
+	RefreshToken TokenRetention `json:"refresh_token,omitempty"`
+	IDToken      TokenRetention `json:"id_token,omitempty"`
+
+	// MaxBytes limits the total size of the tokens in the session. If the
+	// tokens exceed the limit, tokens are dropped in the order
+	// refresh_token, access_token and id_token until the limit is met. The
+	// id_token is dropped last since it is required for the logout. The
+	// limit includes the id_token which is kept for the logout. 0 means no
+	// limit.
+	MaxBytes int `json:"max_bytes,omitempty"`
+}
+
+func (tp *TokenRetentionPolicy) Validate() error {
+	for name, retention := range map[string]TokenRetention{
+		"access_token":  tp.AccessToken,
+		"refresh_token": tp.RefreshToken,
+		"id_token":      tp.IDToken,
+	} {
+		err := retention.validate()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if tp.MaxBytes < 0 {
+		return fmt.Errorf("max bytes must not be negative")
+	}
+	return nil
+}
+
+// modifyScopes removes scopes which are not required by the policy.
+func (tp *TokenRetentionPolicy) modifyScopes(scopes []string) []string {
+	if !tp.RefreshToken.drop() {
+		return scopes
+	}
+	return slices.DeleteFunc(slices.Clone(scopes), func(scope string) bool {
+		return scope == oidc.ScopeOfflineAccess
+	})
+}
+
+// apply removes the tokens from the session according to the policy. If
+// keepIDTokenHint is set a dropped id_token is kept as Session.IDTokenHint.
+func (tp *TokenRetentionPolicy) apply(s *Session, keepIDTokenHint bool) {
+	if s.Tokens == nil {
+		return
+	}
+
+	if tp.AccessToken.drop() {
+		s.Tokens.AccessToken = ""
+	}
+	if tp.RefreshToken.drop() {
+		s.Tokens.RefreshToken = ""
+	}
+	if tp.IDToken.drop() {
+		if keepIDTokenHint {
+			s.IDTokenHint = s.Tokens.IDToken
+		}
+		s.Tokens.IDToken = ""
+	}
+
+	if tp.MaxBytes == 0 {
+		return
+	}
+
+	size := func() int {
+		return len(s.Tokens.AccessToken) + len(s.Tokens.RefreshToken) + len(s.Tokens.IDToken) + len(s.IDTokenHint)
+	}
+	for _, token := range []*string{&s.Tokens.RefreshToken, &s.Tokens.AccessToken, &s.Tokens.IDToken, &s.IDTokenHint} {
+		if size() <= tp.MaxBytes {
+			return
+		}
+		if *token == "" {
+			continue
+		}
+		slog.Warn("drop token from session to meet size limit", "provider_id", s.ProviderID, "size", size(), "max_bytes", tp.MaxBytes, "token_size", len(*token))
+		*token = ""
+	}
+}
@@ -0,0 +1,116 @@
+package oidcproxy
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTokenRetentionModifyScopes(t *testing.T) {
+	for _, test := range []struct {
+		policy   TokenRetentionPolicy
+		scopes   []string
+		expected []string
+	}{
+		{TokenRetentionPolicy{}, []string{"openid", "offline_access", "email"}, []string{"openid", "offline_access", "email"}},
+		{TokenRetentionPolicy{RefreshToken: TokenKeep}, []string{"openid", "offline_access"}, []string{"openid", "offline_access"}},
+		{TokenRetentionPolicy{RefreshToken: TokenDrop}, []string{"openid", "offline_access", "email"}, []string{"openid", "email"}},
+		{TokenRetentionPolicy{RefreshToken: TokenDrop}, []string{"openid"}, []string{"openid"}},
+	} {
+		scopes := slices.Clone(test.scopes)
+		result := test.policy.modifyScopes(scopes)
+		if !slices.Equal(result, test.expected) {
+			t.Errorf("%+v: got %v, want %v", test.policy, result, test.expected)
+		}
+		// the scopes of the caller must not be modified
+		if !slices.Equal(scopes, test.scopes) {
+			t.Errorf("%+v: input scopes modified to %v", test.policy, scopes)
+		}
+	}
+}
+
+func TestTokenRetentionApply(t *testing.T) {
+	newSession := func() *Session {
+		return &Session{Tokens: &Tokens{
+			Token: oauth2.Token{
+				AccessToken:  "access-token",  // 12 bytes
+				RefreshToken: "refresh-token", // 13 bytes
+			},
+			IDToken: "id-token-id-token", // 17 bytes
+		}}
+	}
+
+	for _, test := range []struct {
+		name         string
+		policy       TokenRetentionPolicy
+		endSession   bool
+		accessToken  bool
+		refreshToken bool
+		idToken      bool
+		idTokenHint  bool
+	}{
+		{"keep all", TokenRetentionPolicy{}, true, true, true, true, false},
+		{"drop access_token", TokenRetentionPolicy{AccessToken: TokenDrop}, true, false, true, true, false},
+		{"drop refresh_token", TokenRetentionPolicy{RefreshToken: TokenDrop}, true, true, false, true, false},
+		{"drop id_token", TokenRetentionPolicy{IDToken: TokenDrop}, false, true, true, false, false},
+		{"drop id_token keeps hint for logout", TokenRetentionPolicy{IDToken: TokenDrop}, true, true, true, false, true},
+		{"max bytes not exceeded", TokenRetentionPolicy{MaxBytes: 42}, true, true, true, true, false},
+		{"max bytes drops refresh_token first", TokenRetentionPolicy{MaxBytes: 41}, true, true, false, true, false},
+		{"max bytes drops access_token second", TokenRetentionPolicy{MaxBytes: 24}, true, false, false, true, false},
+		{"max bytes drops all", TokenRetentionPolicy{MaxBytes: 1}, true, false, false, false, false},
+		{"max bytes after drop", TokenRetentionPolicy{IDToken: TokenDrop, MaxBytes: 25}, false, true, true, false, false},
+		{"max bytes includes hint", TokenRetentionPolicy{IDToken: TokenDrop, MaxBytes: 25}, true, false, false, false, true},
+		{"max bytes drops hint", TokenRetentionPolicy{IDToken: TokenDrop, MaxBytes: 1}, true, false, false, false, false},
+	} {
+		s := newSession()
+		test.policy.apply(s, test.endSession)
+		if s.HasAccessToken() != test.accessToken || s.HasRefreshToken() != test.refreshToken || s.HasIDToken() != test.idToken || (s.IDTokenHint != "") != test.idTokenHint {
+			t.Errorf("%s: got access_token=%t refresh_token=%t id_token=%t id_token_hint=%t", test.name, s.HasAccessToken(), s.HasRefreshToken(), s.HasIDToken(), s.IDTokenHint != "")
+		}
+	}
+
+	// sessions without tokens are ignored
+	(&TokenRetentionPolicy{MaxBytes: 1}).apply(&Session{}, true)
+}
+
+func TestTokenRetentionValidate(t *testing.T) {
+	for _, test := range []struct {
+		policy TokenRetentionPolicy
+		valid  bool
+	}{
+		{TokenRetentionPolicy{}, true},
+		{TokenRetentionPolicy{AccessToken: TokenDrop, RefreshToken: TokenKeep}, true},
+		{TokenRetentionPolicy{IDToken: "remove"}, false},
+		{TokenRetentionPolicy{MaxBytes: -1}, false},
+	} {
+		err := test.policy.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%+v: got err=%v, want valid=%t", test.policy, err, test.valid)
+		}
+	}
+}
+
+func TestEndSessionEndpointIDTokenHint(t *testing.T) {
+	p := &Provider{id: "p1", config: &ProviderConfig{ClientID: "client"}}
+	p.state.Store(&providerState{endpoints: Endpoints{EndSessionEndpoint: "https://idp.example.com/logout"}})
+
+	for _, test := range []struct {
+		name    string
+		session *Session
+		query   string
+	}{
+		{"id_token", &Session{Subject: "user1", Tokens: &Tokens{IDToken: "id-token"}}, "id_token_hint=id-token"},
+		{"dropped id_token", &Session{Subject: "user1", Tokens: &Tokens{}, IDTokenHint: "id-token"}, "id_token_hint=id-token"},
+		{"no id_token", &Session{Subject: "user1", Tokens: &Tokens{}}, "client_id=client"},
+	} {
+		endSessionURL, err := p.EndSessionEndpoint(context.Background(), test.session, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endSessionURL != "https://idp.example.com/logout?"+test.query {
+			t.Errorf("%s: unexpected end session url %s", test.name, endSessionURL)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxAPIResponseSize limits the size of the responses of the provider APIs.
+const maxAPIResponseSize = 1 << 20
+
+// apiGet requests url with the access token and decodes the JSON response
+// into v.
+func (p *Provider) apiGet(ctx context.Context, accessToken string, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1000))
+		return fmt.Errorf("request to %s failed: returned status code %d with body '%s'", url, resp.StatusCode, body)
+	}
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxAPIResponseSize)).Decode(v)
+	if err != nil {
+		return fmt.Errorf("invalid response from %s: %w", url, err)
+	}
+	return nil
+}
+
+// UserExtra contains additional information about the user which is stored in
+// User.Extra by the API based user lookups.
+type UserExtra struct {
+	EMail       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// userID is a user ID which is either a JSON string or a JSON number. Numbers
+// are kept in their exact decimal form (e.g. 12345678 instead of 1.2345678e+07).
+type userID string
+
+func (id *userID) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*id = userID(s)
+		return nil
+	}
+	var n json.Number
+	err := json.Unmarshal(data, &n)
+	if err != nil {
+		return fmt.Errorf("invalid user id %s", data)
+	}
+	*id = userID(n)
+	return nil
+}
+
+// githubSessionSetupFunc reads the user, the primary email, the organizations
+// and the teams from the GitHub API. The organizations are added as groups
+// (<org>) and the teams as groups in the form <org>/<team>. Only the first 100
+// organizations and teams are considered.
+var githubSessionSetupFunc SessionSetupFunc = func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+	apiURL := p.config.APIURL
+
+	user := struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+		EMail string `json:"email"`
+	}{}
+	err := p.apiGet(ctx, t.AccessToken, apiURL+"/user", &user)
+	if err != nil {
+		return err
+	}
+
+	// the email in the profile is only set if the user made it public
+	if user.EMail == "" {
+		emails := []struct {
+			EMail    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}{}
+		err = p.apiGet(ctx, t.AccessToken, apiURL+"/user/emails", &emails)
+		if err != nil {
+			return err
+		}
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				user.EMail = email.EMail
+			}
+		}
+	}
+
+	orgs := []struct {
+		Login string `json:"login"`
+	}{}
+	err = p.apiGet(ctx, t.AccessToken, apiURL+"/user/orgs?per_page=100", &orgs)
+	if err != nil {
+		return err
+	}
+
+	teams := []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}{}
+	err = p.apiGet(ctx, t.AccessToken, apiURL+"/user/teams?per_page=100", &teams)
+	if err != nil {
+		return err
+	}
+
+	groups := []string{}
+	for _, org := range orgs {
+		groups = append(groups, org.Login)
+	}
+	for _, team := range teams {
+		groups = append(groups, team.Organization.Login+"/"+team.Slug)
+	}
+
+	s.Subject = strconv.FormatInt(user.ID, 10)
+	s.User = &User{
+		ID:     s.Subject,
+		Name:   user.Login,
+		Groups: groups,
+		Extra: &UserExtra{
+			EMail:       user.EMail,
+			DisplayName: user.Name,
+		},
+	}
+	return nil
+}
+
+// gitlabSessionSetupFunc reads the user and the groups from the GitLab API.
+// The full paths of the groups (e.g. group/subgroup) are added as groups. Only
+// the first 100 groups are considered.
+var gitlabSessionSetupFunc SessionSetupFunc = func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+	apiURL := p.config.APIURL
+
+	user := struct {
+		ID       int64  `json:"id"`
+		Username string `json:"username"`
+		Name     string `json:"name"`
+		EMail    string `json:"email"`
+	}{}
+	err := p.apiGet(ctx, t.AccessToken, apiURL+"/user", &user)
+	if err != nil {
+		return err
+	}
+
+	gitlabGroups := []struct {
+		FullPath string `json:"full_path"`
+	}{}
+	err = p.apiGet(ctx, t.AccessToken, apiURL+"/groups?min_access_level=10&per_page=100", &gitlabGroups)
+	if err != nil {
+		return err
+	}
+
+	groups := []string{}
+	for _, group := range gitlabGroups {
+		groups = append(groups, group.FullPath)
+	}
+
+	s.Subject = strconv.FormatInt(user.ID, 10)
+	s.User = &User{
+		ID:     s.Subject,
+		Name:   user.Username,
+		Groups: groups,
+		Extra: &UserExtra{
+			EMail:       user.EMail,
+			DisplayName: user.Name,
+		},
+	}
+	return nil
+}
+
+// bitbucketSessionSetupFunc reads the user, the primary email and the
+// workspaces from the Bitbucket API. The slugs of the workspaces are added as
+// groups. Only the first 100 workspaces are considered.
+var bitbucketSessionSetupFunc SessionSetupFunc = func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+	apiURL := p.config.APIURL
+
+	user := struct {
+		UUID        string `json:"uuid"`
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+	}{}
+	err := p.apiGet(ctx, t.AccessToken, apiURL+"/user", &user)
+	if err != nil {
+		return err
+	}
+
+	emails := struct {
+		Values []struct {
+			EMail       string `json:"email"`
+			IsPrimary   bool   `json:"is_primary"`
+			IsConfirmed bool   `json:"is_confirmed"`
+		} `json:"values"`
+	}{}
+	err = p.apiGet(ctx, t.AccessToken, apiURL+"/user/emails", &emails)
+	if err != nil {
+		return err
+	}
+	email := ""
+	for _, e := range emails.Values {
+		if e.IsPrimary && e.IsConfirmed {
+			email = e.EMail
+		}
+	}
+
+	workspaces := struct {
+		Values []struct {
+			Workspace struct {
+				Slug string `json:"slug"`
+			} `json:"workspace"`
+		} `json:"values"`
+	}{}
+	err = p.apiGet(ctx, t.AccessToken, apiURL+"/user/permissions/workspaces?pagelen=100", &workspaces)
+	if err != nil {
+		return err
+	}
+
+	groups := []string{}
+	for _, w := range workspaces.Values {
+		groups = append(groups, w.Workspace.Slug)
+	}
+
+	s.Subject = user.UUID
+	s.User = &User{
+		ID:     user.UUID,
+		Name:   user.Username,
+		Groups: groups,
+		Extra: &UserExtra{
+			EMail:       email,
+			DisplayName: user.DisplayName,
+		},
+	}
+	return nil
+}
+
+// userinfoSessionSetupFunc reads the user from the userinfo endpoint. This is
+// used for generic OAuth2 providers. If no userinfo endpoint is configured the
+// session has no user.
+var userinfoSessionSetupFunc SessionSetupFunc = func(ctx context.Context, p *Provider, t *TokenResponse, s *Session) error {
+	state, err := p.currentState()
+	if err != nil {
+		return err
+	}
+	if state.endpoints.UserinfoEndpoint == "" {
+		return nil
+	}
+
+	userinfo := struct {
+		Subject           string   `json:"sub"`
+		ID                userID   `json:"id"`
+		EMail             string   `json:"email"`
+		Name              string   `json:"name"`
+		PreferredUsername string   `json:"preferred_username"`
+		Login             string   `json:"login"`
+		Username          string   `json:"username"`
+		Groups            []string `json:"groups"`
+	}{}
+	err = p.apiGet(ctx, t.AccessToken, state.endpoints.UserinfoEndpoint, &userinfo)
+	if err != nil {
+		return err
+	}
+
+	id := userinfo.Subject
+	if id == "" {
+		id = string(userinfo.ID)
+	}
+
+	name := userinfo.EMail
+	for _, n := range []string{userinfo.PreferredUsername, userinfo.Login, userinfo.Username} {
+		if name == "" {
+			name = n
+		}
+	}
+
+	s.Subject = id
+	s.User = &User{
+		ID:     id,
+		Name:   name,
+		Groups: userinfo.Groups,
+		Extra: &UserExtra{
+			EMail:       userinfo.EMail,
+			DisplayName: userinfo.Name,
+		},
+	}
+	return nil
+}
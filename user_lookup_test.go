@@ -0,0 +1,138 @@
+package oidcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func newGitHubTestServer() *httptest.Server {
+	responses := map[string]any{
+		"/login/oauth/access_token": map[string]any{
+			"access_token": "gho_test",
+			"token_type":   "bearer",
+			"scope":        "read:user,user:email,read:org",
+		},
+		"/user": map[string]any{
+			"id":    42,
+			"login": "octocat",
+			"name":  "The Octocat",
+		},
+		"/user/emails": []map[string]any{
+			{"email": "other@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		},
+		"/user/orgs": []map[string]any{
+			{"login": "acme"},
+		},
+		"/user/teams": []map[string]any{
+			{"slug": "devs", "organization": map[string]any{"login": "acme"}},
+		},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/login/oauth/access_token" && r.Header.Get("Authorization") != "Bearer gho_test" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func TestGitHubProvider(t *testing.T) {
+	server := newGitHubTestServer()
+	defer server.Close()
+
+	newProvider := func(allowedGroups ...string) *Provider {
+		p, err := NewProvider(context.Background(), ProviderConfig{
+			Type:          ProviderTypeGitHub,
+			ClientID:      "client",
+			ClientSecret:  "secret",
+			APIURL:        server.URL,
+			AllowedGroups: allowedGroups,
+			Endpoints: Endpoints{
+				AuthorizationEndpoint: server.URL + "/login/oauth/authorize",
+				TokenEndpoint:         server.URL + "/login/oauth/access_token",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	session, err := newProvider("acme/devs").Exchange(context.Background(), "code")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Subject != "42" || session.User.ID != "42" || session.User.Name != "octocat" {
+		t.Errorf("unexpected user %+v", session.User)
+	}
+	if !slices.Equal(session.User.Groups, []string{"acme", "acme/devs"}) {
+		t.Errorf("unexpected groups %v", session.User.Groups)
+	}
+	if extra, ok := session.User.Extra.(*UserExtra); !ok || extra.EMail != "octocat@example.com" {
+		t.Errorf("unexpected extra %+v", session.User.Extra)
+	}
+
+	_, err = newProvider("other-org").Exchange(context.Background(), "code")
+	if err == nil {
+		t.Fatal("expected error for user which is not member of the allowed groups")
+	}
+}
+
+func TestOAuth2UserinfoProvider(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		userinfo string
+		id       string
+	}{
+		{"numeric id", `{"id": 12345678901, "login": "jane"}`, "12345678901"},
+		{"string id", `{"id": "u-42", "login": "jane"}`, "u-42"},
+		{"sub", `{"sub": "s-1", "id": 42, "login": "jane"}`, "s-1"},
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch r.URL.Path {
+			case "/token":
+				_, _ = w.Write([]byte(`{"access_token": "at", "token_type": "bearer"}`))
+			case "/userinfo":
+				_, _ = w.Write([]byte(test.userinfo))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+
+		p, err := NewProvider(context.Background(), ProviderConfig{
+			Type:         ProviderTypeOAuth2,
+			ClientID:     "client",
+			ClientSecret: "secret",
+			Endpoints: Endpoints{
+				AuthorizationEndpoint: server.URL + "/authorize",
+				TokenEndpoint:         server.URL + "/token",
+				UserinfoEndpoint:      server.URL + "/userinfo",
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := p.Exchange(context.Background(), "code")
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if session.Subject != test.id || session.User.ID != test.id || session.User.Name != "jane" {
+			t.Errorf("%s: unexpected user %+v", test.name, session.User)
+		}
+	}
+}